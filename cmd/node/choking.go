@@ -0,0 +1,182 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/ticker"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	UploadSlots             = 4 // Number of peers sent chunks at the same time, including the optimistic unchoke
+	ChokeInterval           = 10 * time.Second
+	OptimisticUnchokeRounds = 3                 // Number of rounds the same peer is optimistically unchoked for
+	InterestTimeout         = 2 * ChokeInterval // Time without requests after which a peer is no longer interested
+)
+
+// UploadScheduler decides which of the peers requesting chunks are sent them (unchoked).
+// Every round, the peers which uploaded the most to this node recently are unchoked (tit-for-tat),
+// plus a random one (optimistic unchoke), so new peers get the chance to start uploading too.
+type UploadScheduler struct {
+	sync.Mutex
+	interested map[string]time.Time // Peer address -> Last time it requested chunks
+	unchoked   map[string]bool
+	optimistic string // Peer optimistically unchoked, if any
+	round      uint
+}
+
+func NewUploadScheduler() *UploadScheduler {
+	return &UploadScheduler{
+		interested: make(map[string]time.Time),
+		unchoked:   make(map[string]bool),
+	}
+}
+
+// Allow records that the peer requested chunks, and reports whether it is unchoked.
+// Peers are unchoked right away while there are free upload slots.
+func (us *UploadScheduler) Allow(addr string) bool {
+	us.Lock()
+	defer us.Unlock()
+
+	us.interested[addr] = time.Now()
+
+	if !us.unchoked[addr] && len(us.unchoked) < UploadSlots {
+		us.unchoked[addr] = true
+	}
+
+	return us.unchoked[addr]
+}
+
+// Reschedule chooses the peers unchoked until the next round by their upload rate to this node,
+// and returns the peers which were choked and unchoked
+func (us *UploadScheduler) Reschedule(rate func(addr string) float64) ([]string, []string) {
+	us.Lock()
+	defer us.Unlock()
+
+	candidates := make([]string, 0, len(us.interested))
+	for addr, lastRequest := range us.interested {
+		if time.Since(lastRequest) > InterestTimeout {
+			delete(us.interested, addr)
+			delete(us.unchoked, addr)
+			continue
+		}
+
+		candidates = append(candidates, addr)
+	}
+
+	// Shuffled first, so peers with the same rate (e.g. when only seeding) take turns
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	rates := make(map[string]float64, len(candidates))
+	for _, addr := range candidates {
+		rates[addr] = rate(addr)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return rates[candidates[i]] > rates[candidates[j]]
+	})
+
+	unchoked := make(map[string]bool, UploadSlots)
+	for _, addr := range candidates {
+		if len(unchoked) == UploadSlots-1 {
+			break
+		}
+		unchoked[addr] = true
+	}
+
+	// The optimistic unchoke changes every few rounds, or when its peer is no longer interested or was unchoked on its own
+	_, interested := us.interested[us.optimistic]
+	if us.round%OptimisticUnchokeRounds == 0 || !interested || unchoked[us.optimistic] {
+		others := make([]string, 0, len(candidates))
+		for _, addr := range candidates {
+			if !unchoked[addr] {
+				others = append(others, addr)
+			}
+		}
+
+		us.optimistic = ""
+		if len(others) != 0 {
+			us.optimistic = others[rand.Intn(len(others))]
+		}
+	}
+	if us.optimistic != "" {
+		unchoked[us.optimistic] = true
+	}
+	us.round++
+
+	var choked, newlyUnchoked []string
+	for addr := range us.unchoked {
+		if !unchoked[addr] {
+			choked = append(choked, addr)
+		}
+	}
+	for addr := range unchoked {
+		if !us.unchoked[addr] {
+			newlyUnchoked = append(newlyUnchoked, addr)
+		}
+	}
+	us.unchoked = unchoked
+
+	return choked, newlyUnchoked
+}
+
+func (n *Node) startChoking() {
+	tck := ticker.NewTicker(ChokeInterval, n.rescheduleUploads)
+	tck.Start()
+	n.chokeTck = tck
+}
+
+// Chooses the peers unchoked for the next round, telling the peers whose state changed
+func (n *Node) rescheduleUploads() {
+	choked, unchoked := n.uploads.Reschedule(func(addr string) float64 {
+		return n.nodeStatistics.getRecentDownloadRate(addr, InterestTimeout)
+	})
+
+	chokePacket := protocol.NewChokePacket()
+	for _, peer := range choked {
+		if addr, err := net.ResolveUDPAddr("udp4", peer); err == nil {
+			n.srv.SendPacket(&chokePacket, addr)
+		}
+	}
+
+	unchokePacket := protocol.NewUnchokePacket()
+	for _, peer := range unchoked {
+		if addr, err := net.ResolveUDPAddr("udp4", peer); err == nil {
+			n.srv.SendPacket(&unchokePacket, addr)
+		}
+	}
+}
+
+// Returns whether the node choked this one recently. Once in a while chunks are requested anyway,
+// in case the node unchoked this one but its UnchokePacket was lost.
+func (n *Node) isChokedBy(addr string) bool {
+	chokedAt, ok := n.chokedBy.Get(addr)
+	return ok && time.Since(chokedAt) < ChokeInterval
+}
+
+// Handler for when a node will not send chunks to this one. The chunks requested from it are released,
+// so they can be requested from other nodes, without shrinking its congestion window as if they were lost.
+func (n *Node) handleChokePacket(_ *protocol.ChokePacket, addr *net.UDPAddr) {
+	if !n.chokedBy.Contains(addr.String()) {
+		logger.Info("Choked by node %s", addr)
+	}
+
+	n.chokedBy.Put(addr.String(), time.Now())
+
+	for _, request := range n.congestionController(addr.String()).Cancel() {
+		if file, ok := n.forDownload.Get(request.FileHash); ok && file.UpdatedByTracker {
+			file.PendingChunks.Delete(request.Chunk)
+		}
+	}
+}
+
+// Handler for when a node, which choked this one, will send chunks again
+func (n *Node) handleUnchokePacket(_ *protocol.UnchokePacket, addr *net.UDPAddr) {
+	logger.Info("Unchoked by node %s", addr)
+	n.chokedBy.Delete(addr.String())
+}
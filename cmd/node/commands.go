@@ -2,41 +2,121 @@ package main
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/multifile"
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/utils"
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 )
 
+// connect <tracker address>
 func (n *Node) connect(args []string) error {
-	if n.connected {
-		logger.Info("Already connected to tracker on %s", n.trackerAddr)
+	addr := args[0]
+
+	trackers := n.trackers.Filter(func(tracker *TrackerConnection) bool { return tracker.addr == addr })
+	if len(trackers) == 0 {
+		trackers = append(trackers, NewTrackerConnection(addr))
+		n.trackers.Add(trackers[0])
+	}
+	tracker := trackers[0]
+
+	if tracker.connected.Load() {
+		logger.Info("Already connected to tracker on %s", addr)
+		return nil
+	}
+
+	if tracker.reconnecting.Load() {
+		logger.Info("Already reconnecting to tracker on %s", addr)
 		return nil
 	}
 
-	go n.startTCP()
+	go func() {
+		err := n.dialTracker(tracker)
+		if err != nil {
+			logger.Error("No tracker to connect found on %s. Try again later with the 'connect' command", addr)
+		}
+	}()
 
 	return nil
 }
 
-// request <file name>
+// request <file name | file hash>
 func (n *Node) requestFile(args []string) error {
-	filename := args[0]
+	fileName := args[0]
 
-	packet := protocol.NewRequestFilePacket(filename)
-	n.conn.EnqueuePacket(&packet)
+	// Files can be requested by their hash or by their name
+	fileHash, err := utils.HexToHash(fileName)
+	if err == nil {
+		fileName = ""
+	}
 
-	// Data of the file will be updated later, when the tracker responds back
-	n.forDownload.Put(filename, NewForDownloadFile(filename))
+	downloading := false
+	n.forDownload.ForEach(func(hash [20]byte, file *ForDownloadFile) {
+		if hash == fileHash || file.FileName == fileName {
+			downloading = true
+		}
+	})
+
+	if downloading {
+		return fmt.Errorf("file %s is already being downloaded", args[0])
+	}
+
+	// Without a tracker, the peers of the file are looked up in the DHT, where files are only known by their hash
+	if !n.isConnected() {
+		if fileName != "" {
+			return fmt.Errorf("not connected to any tracker, so files can only be requested by their hash")
+		}
+
+		n.dht.wanted.Put(fileHash, true)
+		go n.requestFromDHT(fileHash)
+
+		return nil
+	}
+
+	// The download starts when the first tracker responds back with the data of the file,
+	// and the nodes sent by every tracker are merged
+	packet := protocol.NewRequestFilePacket(fileName, fileHash)
+	n.sendToTrackers(&packet)
 
 	return nil
 }
 
-// publish <file name>
+// search <pattern>
+func (n *Node) search(args []string) error {
+	packet := protocol.NewSearchPacket(args[0])
+	return n.sendToTracker(&packet)
+}
+
+// ls
+func (n *Node) listFiles(_ []string) error {
+	packet := protocol.NewListFilesPacket()
+	return n.sendToTracker(&packet)
+}
+
+// peers <file name | file hash>
+func (n *Node) listPeers(args []string) error {
+	fileName := args[0]
+
+	fileHash, err := utils.HexToHash(fileName)
+	if err == nil {
+		fileName = ""
+	}
+
+	packet := protocol.NewRequestPeersPacket(fileName, fileHash)
+	return n.sendToTracker(&packet)
+}
+
+// publish <file name | directory>
 func (n *Node) publish(args []string) error {
 	path := args[0]
 
+	if !n.isAuthenticated() {
+		return fmt.Errorf("not authenticated with any tracker")
+	}
+
 	// Check if the path is a file or a directory
 	switch info, err := os.Stat(path); {
 	case err != nil:
@@ -57,101 +137,174 @@ func (n *Node) publish(args []string) error {
 }
 
 func (n *Node) publishFile(path string) error {
-	file, err := os.Open(path)
+	return n.publishFiles(path, nil)
+}
+
+// Publishes a directory as a single file, with a manifest of every file in its tree
+func (n *Node) publishDirectory(path string) error {
+	files, _, err := multifile.BuildManifest(path)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	fileName := filepath.Base(path)
 
-	fileHash, err := utils.HashFile(file)
-	if err != nil {
-		return err
+	if len(files) == 0 {
+		return fmt.Errorf("directory %s has no files", path)
 	}
 
-	chunkHashes := make([][20]byte, 0)
-	fileSize, err := utils.HashFileChunks(file, &chunkHashes)
+	return n.publishFiles(path, files)
+}
+
+func (n *Node) publishFiles(path string, files []protocol.ManifestEntry) error {
+	newFile, packet, err := n.hashFiles(path, files)
 	if err != nil {
 		return err
 	}
 
-	newFile := NewFile(fileName, path)
-	n.pending.Put(fileName, &newFile)
-	logger.Info("Added file %s to pending files", fileName)
+	n.pending.Put(newFile.FileHash, newFile)
+	logger.Info("Added file %s to pending files", newFile.FileName)
 
-	packet := protocol.NewPublishFilePacket(fileName, fileSize, fileHash, chunkHashes)
-	n.conn.EnqueuePacket(&packet)
-	logger.Info("Sent publish file packet to tracker")
+	n.sendToAuthenticatedTrackers(packet)
+	logger.Info("Sent publish file packet to trackers")
 
 	return nil
 }
 
-func (n *Node) publishDirectory(path string) error {
-	err := filepath.WalkDir(path, func(current_path string, d os.DirEntry, err error) error {
-		if path != current_path {
-			switch d.IsDir() {
-			case true:
-				return n.publishDirectory(current_path)
-			case false:
-				return n.publishFile(current_path)
-			}
-
-			return nil
+// Hashes the file (or every file of the directory), returning the packet to publish it
+func (n *Node) hashFiles(path string, files []protocol.ManifestEntry) (*File, *protocol.PublishFilePacket, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	file, err := multifile.Open(path, files)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	fileName := filepath.Base(path)
+	fileSize := file.Size()
+	content := bufio.NewReaderSize(io.NewSectionReader(file, 0, int64(fileSize)), HashingBufferSize)
+
+	// Hash the file and its chunks in a single pass, announcing the progress of large files
+	const AnnouncePercentageInterval = 10
+	announced := 0
+	progress := func(hashed uint64) {
+		percentage := int(float64(hashed)/float64(fileSize)*100) / AnnouncePercentageInterval * AnnouncePercentageInterval
+		if percentage > announced && fileSize > HashingProgressThreshold {
+			announced = percentage
+			logger.Info("Hashing file %s: %d%%", fileName, percentage)
 		}
+	}
 
-		return nil
-	})
+	fileHash, chunkHashes, err := utils.HashFileStream(content, fileSize, n.hasher, progress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// In merkle hash mode, only the root of the tree is sent to the tracker
+	var tree *utils.MerkleTree
+	var merkleRoot []byte
+	if n.hashMode == protocol.HashModeMerkle {
+		tree = utils.NewMerkleTree(n.hasher, chunkHashes)
+		merkleRoot = tree.Root()
+		chunkHashes = nil
+	}
 
-	return err
+	packet := protocol.NewPublishFilePacket(fileName, fileSize, fileHash, n.hasher.Algorithm(), n.hashMode, chunkHashes, merkleRoot, files)
+	newFile := NewFile(fileName, fileHash, path, files, tree)
+	newFile.PublishPacket = &packet
+
+	return &newFile, &packet, nil
 }
 
 // status
 func (n *Node) status(_ []string) error {
-	if n.connected {
-		logger.Info("Connected to tracker on %s", n.trackerAddr)
-	} else {
-		logger.Info("Not connected to tracker. Run 'connect' in order to do so")
-	}
+	n.trackers.ForEach(func(tracker *TrackerConnection) {
+		switch {
+		case tracker.authenticated.Load():
+			logger.Info("Connected to tracker on %s", tracker.addr)
+		case tracker.connected.Load():
+			logger.Info("Connected to tracker on %s, but not authenticated", tracker.addr)
+		case tracker.reconnecting.Load():
+			logger.Info("Reconnecting to tracker on %s", tracker.addr)
+		default:
+			logger.Info("Not connected to tracker on %s. Run 'connect' in order to do so", tracker.addr)
+		}
+	})
+
+	logger.Info("Contacts in the DHT: %d", n.dht.table.Len())
 
 	if n.pending.Len() != 0 {
 		logger.Info("Pending files:")
-		n.pending.ForEach(func(filename string, file *File) {
-			logger.Info("%s at %s", file.FileName, file.Path)
+		n.pending.ForEach(func(fileHash [20]byte, file *File) {
+			logger.Info("%s (%x) at %s", file.FileName, fileHash, file.Path)
 		})
 	}
 
 	if n.published.Len() != 0 {
 		logger.Info("Published files:")
-		n.published.ForEach(func(filename string, file *File) {
-			logger.Info("%s at %s", file.FileName, file.Path)
+		n.published.ForEach(func(fileHash [20]byte, file *File) {
+			logger.Info("%s (%x) at %s", file.FileName, fileHash, file.Path)
 		})
 	}
 
 	if n.forDownload.Len() != 0 {
 		logger.Info("Files for download:")
-		n.forDownload.ForEach(func(fileName string, file *ForDownloadFile) {
-			logger.Info("%s with size %d", fileName, file.FileSize)
+		n.forDownload.ForEach(func(fileHash [20]byte, file *ForDownloadFile) {
+			logger.Info("%s (%x) with size %d", file.FileName, fileHash, file.FileSize)
 			len := uint16(file.LengthOfMissingChunks())
 			logger.Info("Chunks progress %d/%d (%.2f%%)", file.NumberOfChunks-len, file.NumberOfChunks, float64(file.NumberOfChunks-len)/float64(file.NumberOfChunks)*100)
 		})
 	}
 
 	logger.Info("Download directory path: %s", n.downloadDirectory)
+	logger.Info("Upload limit: %s", formatLimit(n.uploadLimit.Rate()))
+	logger.Info("Download limit: %s", formatLimit(n.downloadLimit.Rate()))
 
 	return nil
 }
 
-// remove <file name>
+// remove <file name | file hash>
 func (n *Node) removeFile(args []string) error {
-	filename := args[0]
+	if !n.isAuthenticated() {
+		return fmt.Errorf("not authenticated with any tracker")
+	}
 
-	packet := protocol.NewRemoveFilePacket(filename)
-	n.conn.EnqueuePacket(&packet)
+	// Files can be removed by their hash or by the name of a published file
+	fileHash, err := utils.HexToHash(args[0])
+	if err != nil {
+		fileHash, err = n.findPublishedFile(args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	packet := protocol.NewRemoveFilePacket(fileHash)
+	n.sendToAuthenticatedTrackers(&packet)
 
 	return nil
 }
 
+// Returns the hash of the only published file with the given name
+func (n *Node) findPublishedFile(fileName string) ([20]byte, error) {
+	var hashes [][20]byte
+	n.published.ForEach(func(fileHash [20]byte, file *File) {
+		if file.FileName == fileName {
+			hashes = append(hashes, fileHash)
+		}
+	})
+
+	switch len(hashes) {
+	case 0:
+		return [20]byte{}, fmt.Errorf("file %s is not published", fileName)
+	case 1:
+		return hashes[0], nil
+	default:
+		return [20]byte{}, fmt.Errorf("there are %d published files named %s, use the file hash instead", len(hashes), fileName)
+	}
+}
+
 // path <path>
 func (n *Node) setDownloadDirectory(args []string) error {
 	path := args[0]
@@ -166,10 +319,40 @@ func (n *Node) setDownloadDirectory(args []string) error {
 	}
 
 	n.downloadDirectory = path
+	n.resumeDownloads(path)
+
+	return nil
+}
+
+// limit up|down <rate>
+func (n *Node) setLimit(args []string) error {
+	rate, err := utils.ParseRate(args[1])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		n.uploadLimit.SetRate(rate)
+		logger.Info("Upload limit: %s", formatLimit(rate))
+	case "down":
+		n.downloadLimit.SetRate(rate)
+		logger.Info("Download limit: %s", formatLimit(rate))
+	default:
+		return fmt.Errorf("unknown limit %s, use up or down", args[0])
+	}
 
 	return nil
 }
 
+func formatLimit(rate uint64) string {
+	if rate == 0 {
+		return "none"
+	}
+
+	return fmt.Sprintf("%d bytes/s", rate)
+}
+
 // statistics
 func (n *Node) statistics(_ []string) error {
 	statistics := n.nodeStatistics
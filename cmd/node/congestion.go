@@ -0,0 +1,35 @@
+package main
+
+import "PessiTorrent/internal/transport"
+
+// Returns the congestion controller of the peer with the given address, creating it if needed
+func (n *Node) congestionController(addr string) *transport.CongestionController[ChunkRequest] {
+	n.peers.Lock()
+	defer n.peers.Unlock()
+
+	cc, ok := n.peers.M[addr]
+	if !ok {
+		cc = transport.NewCongestionController[ChunkRequest]()
+		n.peers.M[addr] = cc
+	}
+
+	return cc
+}
+
+// Key identifying a chunk request in the congestion controller of a peer
+type ChunkRequest struct {
+	FileHash [20]byte
+	Chunk    uint16
+}
+
+// Releases the chunks whose requests were lost, so they can be requested again.
+// Must be called while holding the lock of the forDownload map.
+func (n *Node) expireChunkRequests() {
+	n.peers.ForEach(func(addr string, cc *transport.CongestionController[ChunkRequest]) {
+		for _, request := range cc.Expire() {
+			if file, ok := n.forDownload.M[request.FileHash]; ok && file.UpdatedByTracker {
+				file.PendingChunks.Delete(request.Chunk)
+			}
+		}
+	})
+}
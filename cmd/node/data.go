@@ -4,23 +4,40 @@ import (
 	"PessiTorrent/internal/filewriter"
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/structures"
+	"PessiTorrent/internal/utils"
+	"bytes"
+	"encoding/hex"
+	"fmt"
 	"net"
+	"path/filepath"
 	"time"
 )
 
 const (
 	ChunkTimeout = 500 * time.Millisecond
+
+	// Number of bytes of the file hash added to the name of a download
+	ShortHashSize = 4
 )
 
 type File struct {
 	FileName string
+	FileHash [20]byte
 	Path     string
+	Files    []protocol.ManifestEntry // Files of the directory, if the file is one
+	Tree     *utils.MerkleTree        // Merkle tree of the chunks, only in merkle hash mode
+
+	// Packet to publish the file again, when reconnecting to the tracker
+	PublishPacket *protocol.PublishFilePacket
 }
 
-func NewFile(fileName string, path string) File {
+func NewFile(fileName string, fileHash [20]byte, path string, files []protocol.ManifestEntry, tree *utils.MerkleTree) File {
 	return File{
 		FileName: fileName,
+		FileHash: fileHash,
 		Path:     path,
+		Files:    files,
+		Tree:     tree,
 	}
 }
 
@@ -31,27 +48,39 @@ type ForDownloadFile struct {
 	// Timestamp of when the download started
 	DownloadStarted time.Time
 
-	FileName   string
-	FilePath   string
-	FileHash   [20]byte
-	FileSize   uint64
-	FileWriter *filewriter.FileWriter
+	FileName    string
+	FilePath    string
+	FileHash    [20]byte
+	FileSize    uint64
+	Files       []protocol.ManifestEntry // Files of the directory, if the file is one
+	Hasher      utils.Hasher
+	ChunkHashes [][]byte          // Hash of every chunk, only in flat hash mode
+	Tree        *utils.MerkleTree // Merkle tree of the chunks, only in merkle hash mode
+	FileWriter  *filewriter.FileWriter
 
 	// Last time the node sent a UpdateChunksPacket to the tracker
 	LastServerChunksUpdate time.Time
 
+	// Last time the node asked the tracker for every node with chunks of the file, with a UpdateFilePacket
+	LastNodesUpdate time.Time
+
+	// Last time the download state was saved to disk
+	LastStateSave time.Time
+
 	NumberOfChunks uint16
 	Chunks         structures.SynchronizedList[ChunkInfo]
 
 	PendingChunks structures.SynchronizedMap[uint16, time.Time] // Chunk index -> Last time chunk was requested
 
 	Nodes structures.SynchronizedMap[string, *NodeInfo]
+
+	// Called when a chunk is written to disk, if set
+	OnChunkDownloaded func(chunkIndex uint16)
 }
 
 type ChunkInfo struct {
 	Index      uint16
 	Downloaded bool
-	Hash       [20]byte
 }
 
 type NodeInfo struct {
@@ -66,19 +95,31 @@ type RequestInfo struct {
 	NumberOfTries     uint
 }
 
-func NewForDownloadFile(fileName string) *ForDownloadFile {
+func NewForDownloadFile(fileName string, fileHash [20]byte) *ForDownloadFile {
 	return &ForDownloadFile{
 		UpdatedByTracker:       false,
 		FileName:               fileName,
+		FileHash:               fileHash,
 		LastServerChunksUpdate: time.Now(),
+		LastNodesUpdate:        time.Now(),
 	}
 }
 
-func (f *ForDownloadFile) SetData(fileHash [20]byte, chunkHashes [][20]byte, fileSize uint64, numberOfChunks uint16, downloadDirectory string) error {
-	f.FileHash = fileHash
+// Sets the data of the file sent by the tracker. Chunks are verified with their hashes or,
+// if they are not known (in merkle hash mode), with the merkle tree.
+func (f *ForDownloadFile) SetData(hasher utils.Hasher, chunkHashes [][]byte, tree *utils.MerkleTree, fileSize uint64, numberOfChunks uint16, files []protocol.ManifestEntry, downloadDirectory string) error {
+	// The name comes from the tracker, so it must not escape the download directory
+	if f.FileName == "." || !filepath.IsLocal(f.FileName) || filepath.Base(f.FileName) != f.FileName {
+		return fmt.Errorf("invalid file name %s", f.FileName)
+	}
+
 	f.FileSize = fileSize
-	f.FilePath = downloadDirectory + "/" + f.FileName
-	fileWriter, err := filewriter.NewFileWriter(f.FileName, fileSize, f.MarkChunkAsDownloaded, f.FilePath)
+	f.Files = files
+	f.Hasher = hasher
+	f.ChunkHashes = chunkHashes
+	f.Tree = tree
+	f.FilePath = filepath.Join(downloadDirectory, DownloadName(f.FileName, f.FileHash))
+	fileWriter, err := filewriter.NewFileWriter(f.FileName, fileSize, f.MarkChunkAsDownloaded, f.FilePath, files)
 	if err != nil {
 		return err
 	}
@@ -91,7 +132,6 @@ func (f *ForDownloadFile) SetData(fileHash [20]byte, chunkHashes [][20]byte, fil
 		_ = f.Chunks.Set(uint(i), ChunkInfo{
 			Index:      uint16(i),
 			Downloaded: false,
-			Hash:       chunkHashes[i],
 		})
 	}
 
@@ -101,17 +141,45 @@ func (f *ForDownloadFile) SetData(fileHash [20]byte, chunkHashes [][20]byte, fil
 	return nil
 }
 
+// Returns the name a file is downloaded as. Different files may have the same name,
+// so part of the hash is added to it, before the extension.
+func DownloadName(fileName string, fileHash [20]byte) string {
+	extension := filepath.Ext(fileName)
+	if extension == fileName {
+		extension = "" // Hidden files, such as .bashrc
+	}
+
+	return fmt.Sprintf("%s-%s%s", fileName[:len(fileName)-len(extension)], hex.EncodeToString(fileHash[:ShortHashSize]), extension)
+}
+
+// Returns the packet to publish the file, once it is downloaded
+func (f *ForDownloadFile) PublishPacket() *protocol.PublishFilePacket {
+	hashMode := uint8(protocol.HashModeFlat)
+	var merkleRoot []byte
+	if f.Tree != nil {
+		hashMode = protocol.HashModeMerkle
+		merkleRoot = f.Tree.Root()
+	}
+
+	packet := protocol.NewPublishFilePacket(f.FileName, f.FileSize, f.FileHash, f.Hasher.Algorithm(), hashMode, f.ChunkHashes, merkleRoot, f.Files)
+	return &packet
+}
+
 func (f *ForDownloadFile) IsFileDownloaded() bool {
 	return f.LengthOfMissingChunks() == 0
 }
 
+// Sets the chunks the node has, adding the node if it is not known yet.
+// Known nodes keep their timeouts and the requests of the chunks they still have.
 func (f *ForDownloadFile) UpsertNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
 	if nodeInfo, ok := f.Nodes.Get(nodeAddr.String()); ok {
 		f.updateNode(nodeInfo, bitfield)
 	} else {
 		f.addNode(nodeAddr, bitfield)
 	}
+}
 
+func (f *ForDownloadFile) addNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
 	nodeInfo := NodeInfo{
 		Address: nodeAddr.String(),
 		Chunks:  structures.NewSynchronizedMap[uint16, *RequestInfo](),
@@ -127,33 +195,44 @@ func (f *ForDownloadFile) UpsertNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
 	f.Nodes.Put(nodeAddr.String(), &nodeInfo)
 }
 
-func (f *ForDownloadFile) addNode(nodeAddr *net.UDPAddr, bitfield []uint8) {
-	nodeInfo := NodeInfo{
-		Address: nodeAddr.String(),
-		Chunks:  structures.NewSynchronizedMap[uint16, *RequestInfo](),
+// Adds chunks to the ones the node has, adding the node if it is not known yet
+func (f *ForDownloadFile) AddNodeChunks(nodeAddr *net.UDPAddr, chunks []uint16) {
+	nodeInfo, ok := f.Nodes.Get(nodeAddr.String())
+	if !ok {
+		f.addNode(nodeAddr, nil)
+		nodeInfo, _ = f.Nodes.Get(nodeAddr.String())
 	}
 
-	decoded := protocol.DecodeBitField(bitfield)
-	for index, hasChunk := range decoded {
-		if hasChunk {
-			nodeInfo.Chunks.Put(uint16(index), &RequestInfo{TimeLastRequested: time.Time{}})
+	for _, chunk := range chunks {
+		if chunk < f.NumberOfChunks && !nodeInfo.Chunks.Contains(chunk) {
+			nodeInfo.Chunks.Put(chunk, &RequestInfo{TimeLastRequested: time.Time{}})
 		}
 	}
-
-	f.Nodes.Put(nodeAddr.String(), &nodeInfo)
 }
 
 func (f *ForDownloadFile) updateNode(nodeInfo *NodeInfo, bitfield []uint8) {
 	decoded := protocol.DecodeBitField(bitfield)
 	for index, hasChunk := range decoded {
-		if hasChunk {
+		if hasChunk && !nodeInfo.Chunks.Contains(uint16(index)) {
 			nodeInfo.Chunks.Put(uint16(index), &RequestInfo{TimeLastRequested: time.Time{}})
-		} else {
+		} else if !hasChunk {
 			nodeInfo.Chunks.Delete(uint16(index))
 		}
 	}
 }
 
+// Returns the bitfield of the chunks of the file the node has
+func (f *ForDownloadFile) NodeBitfield(nodeInfo *NodeInfo) protocol.Bitfield {
+	bitfield := make([]bool, f.NumberOfChunks)
+	for _, chunk := range nodeInfo.Chunks.Keys() {
+		if int(chunk) < len(bitfield) {
+			bitfield[chunk] = true
+		}
+	}
+
+	return protocol.EncodeBitField(bitfield)
+}
+
 func (f *ForDownloadFile) MarkChunkAsRequested(chunkIndex uint16, nodeInfo *NodeInfo) {
 	nodeInfo.Chunks.Put(chunkIndex, &RequestInfo{TimeLastRequested: time.Now()})
 }
@@ -162,6 +241,10 @@ func (f *ForDownloadFile) MarkChunkAsDownloaded(chunkIndex uint16) {
 	chunk, _ := f.Chunks.Get(uint(chunkIndex))
 	chunk.Downloaded = true
 	_ = f.Chunks.Set(uint(chunkIndex), chunk)
+
+	if f.OnChunkDownloaded != nil {
+		f.OnChunkDownloaded(chunkIndex)
+	}
 }
 
 func (f *ForDownloadFile) ChunkAlreadyDownloaded(chunkIndex uint16) bool {
@@ -169,9 +252,33 @@ func (f *ForDownloadFile) ChunkAlreadyDownloaded(chunkIndex uint16) bool {
 	return chunk.Downloaded
 }
 
-func (f *ForDownloadFile) GetChunkHash(chunkIndex uint16) [20]byte {
-	chunk, _ := f.Chunks.Get(uint(chunkIndex))
-	return chunk.Hash
+// Returns the size of a chunk of the file, since the last one may be smaller than the others
+func (f *ForDownloadFile) ChunkLength(chunkIndex uint16) uint64 {
+	chunkSize := utils.ChunkSize(f.FileSize)
+	return min(chunkSize, f.FileSize-uint64(chunkIndex)*chunkSize)
+}
+
+func (f *ForDownloadFile) GetChunkHash(chunkIndex uint16) []byte {
+	if f.Tree != nil {
+		hash, _ := f.Tree.Leaf(int(chunkIndex))
+		return hash
+	}
+
+	if int(chunkIndex) >= len(f.ChunkHashes) {
+		return nil
+	}
+
+	return f.ChunkHashes[chunkIndex]
+}
+
+// Returns whether the content of the chunk matches its hash or, in merkle hash mode, its proof
+func (f *ForDownloadFile) VerifyChunk(chunkIndex uint16, chunkContent []uint8, proof [][]byte) bool {
+	hash := utils.HashChunk(chunkContent, f.Hasher)
+	if f.Tree != nil {
+		return f.Tree.Verify(int(chunkIndex), hash, proof)
+	}
+
+	return bytes.Equal(f.GetChunkHash(chunkIndex), hash)
 }
 
 func (f *ForDownloadFile) GetDownloadedChunks() []uint {
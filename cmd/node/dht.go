@@ -0,0 +1,416 @@
+package main
+
+import (
+	"PessiTorrent/internal/dht"
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/structures"
+	"PessiTorrent/internal/ticker"
+	"PessiTorrent/internal/utils"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DHTQueryTimeout    = 1 * time.Second
+	DHTRefreshInterval = 1 * time.Minute
+	DHTPeerTTL         = 5 * DHTRefreshInterval // Time after which peers which did not announce themselves again are forgotten
+)
+
+// DHTNode is the state of the node in the DHT, where nodes find the peers of a file when no tracker is reachable.
+// Peers announce themselves to the nodes whose IDs are closest to the hash of the file.
+type DHTNode struct {
+	id        dht.ID
+	table     *dht.RoutingTable
+	peers     *dht.PeerStore // Peers announced to this node
+	bootstrap []string       // UDP addresses of the nodes used to join the DHT
+
+	queries   structures.SynchronizedMap[uint32, chan protocol.Packet] // Request ID -> Channel of the answer
+	nextQuery atomic.Uint32
+
+	wanted    structures.SynchronizedMap[[20]byte, bool]           // Files requested through the DHT whose download did not start yet
+	fileInfos structures.SynchronizedMap[fileInfoQuery, time.Time] // Peers asked for the information about a file -> When
+}
+
+// Peer asked for the information about a file, the only one whose answer is accepted
+type fileInfoQuery struct {
+	fileHash [20]byte
+	addr     string
+}
+
+func NewDHTNode(bootstrap []string) *DHTNode {
+	id, err := dht.NewRandomID()
+	if err != nil {
+		logger.Error("Failed to generate the DHT node ID: %v", err)
+	}
+
+	return &DHTNode{
+		id:        id,
+		table:     dht.NewRoutingTable(id),
+		peers:     dht.NewPeerStore(DHTPeerTTL),
+		bootstrap: bootstrap,
+
+		queries: structures.NewSynchronizedMap[uint32, chan protocol.Packet](),
+		wanted:  structures.NewSynchronizedMap[[20]byte, bool](),
+
+		fileInfos: structures.NewSynchronizedMap[fileInfoQuery, time.Time](),
+	}
+}
+
+func (n *Node) startDHT() {
+	n.joinDHT()
+
+	tck := ticker.NewTicker(DHTRefreshInterval, n.refreshDHT)
+	tck.Start()
+	n.dhtTck = tck
+}
+
+// Asks the bootstrap nodes for the contacts closest to the node, then looks them up to fill the routing table
+func (n *Node) joinDHT() {
+	if len(n.dht.bootstrap) == 0 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range n.dht.bootstrap {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			requestID := n.dht.nextQuery.Add(1)
+			packet := protocol.NewDHTFindNodePacket(n.dht.id, requestID, n.dht.id)
+			_, err := n.queryDHT(requestID, &packet, addr)
+			if err != nil {
+				logger.Warn("Failed to join the DHT through %s: %v", addr, err)
+			}
+		}(addr)
+	}
+	wg.Wait()
+
+	n.lookupDHT(n.dht.id, false)
+	logger.Info("Joined the DHT with %d contacts", n.dht.table.Len())
+}
+
+// Joins the DHT again if every contact was lost, announces the files again before their announcements expire,
+// looks again for the files requested through the DHT whose download did not start yet and,
+// while no tracker is reachable, looks for new peers of the files being downloaded
+func (n *Node) refreshDHT() {
+	if n.dht.table.Len() == 0 {
+		n.joinDHT()
+	}
+
+	n.dht.peers.Expire()
+	n.expireFileInfoQueries()
+
+	for _, fileHash := range n.announcedFiles() {
+		n.announceToDHT(fileHash)
+	}
+
+	for _, fileHash := range n.dht.wanted.Keys() {
+		// The file may have been downloaded from a tracker in the meantime
+		if n.published.Contains(fileHash) || n.forDownload.Contains(fileHash) {
+			n.dht.wanted.Delete(fileHash)
+			continue
+		}
+
+		n.requestFromDHT(fileHash)
+	}
+
+	if !n.isConnected() {
+		for _, fileHash := range n.forDownload.Keys() {
+			n.requestFromDHT(fileHash)
+		}
+	}
+}
+
+// Returns the hashes of the files the node has chunks of
+func (n *Node) announcedFiles() [][20]byte {
+	fileHashes := n.published.Keys()
+	n.forDownload.ForEach(func(fileHash [20]byte, file *ForDownloadFile) {
+		if file.UpdatedByTracker {
+			fileHashes = append(fileHashes, fileHash)
+		}
+	})
+
+	return fileHashes
+}
+
+// Sends a query to a node of the DHT and waits for its answer
+func (n *Node) queryDHT(requestID uint32, packet protocol.Packet, addr string) (protocol.Packet, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	answer := make(chan protocol.Packet, 1)
+	n.dht.queries.Put(requestID, answer)
+	defer n.dht.queries.Delete(requestID)
+
+	n.srv.SendPacket(packet, udpAddr)
+
+	select {
+	case packet := <-answer:
+		return packet, nil
+	case <-time.After(DHTQueryTimeout):
+		return nil, fmt.Errorf("no answer after %v", DHTQueryTimeout)
+	}
+}
+
+// Iteratively looks up the contacts closest to the target, querying Alpha of them at a time.
+// When looking for the peers of a file, also returns the peers known by this node and every contact which answered.
+func (n *Node) lookupDHT(target dht.ID, findPeers bool) ([]dht.Contact, []string) {
+	lookup := dht.NewLookup(target, n.dht.table.Closest(target, dht.BucketSize))
+
+	peers := make(map[string]bool)
+	if findPeers {
+		for _, peer := range n.dht.peers.Get(target) {
+			peers[peer] = true
+		}
+	}
+
+	type answer struct {
+		contact dht.Contact
+		packet  protocol.Packet
+	}
+
+	for next := lookup.Next(dht.Alpha); len(next) != 0; next = lookup.Next(dht.Alpha) {
+		answers := make(chan answer, len(next))
+		for _, contact := range next {
+			go func(contact dht.Contact) {
+				requestID := n.dht.nextQuery.Add(1)
+
+				var packet protocol.Packet
+				if findPeers {
+					findPeersPacket := protocol.NewDHTFindPeersPacket(n.dht.id, requestID, target)
+					packet = &findPeersPacket
+				} else {
+					findNodePacket := protocol.NewDHTFindNodePacket(n.dht.id, requestID, target)
+					packet = &findNodePacket
+				}
+
+				// The packet is nil if the contact did not answer
+				packet, _ = n.queryDHT(requestID, packet, contact.Addr)
+				answers <- answer{contact, packet}
+			}(contact)
+		}
+
+		// The lookup is only updated here, since it is not safe for concurrent use
+		for range next {
+			answer := <-answers
+
+			switch packet := answer.packet.(type) {
+			case *protocol.DHTNodesPacket:
+				lookup.Answered(answer.contact.ID, n.fromDHTContacts(packet.Nodes))
+			case *protocol.DHTPeersPacket:
+				lookup.Answered(answer.contact.ID, n.fromDHTContacts(packet.Nodes))
+				for _, peer := range packet.Peers {
+					peers[peer] = true
+				}
+			default:
+				lookup.Failed(answer.contact.ID)
+				n.dht.table.Remove(answer.contact.ID)
+			}
+		}
+	}
+
+	addrs := make([]string, 0, len(peers))
+	for peer := range peers {
+		addrs = append(addrs, peer)
+	}
+	sort.Strings(addrs)
+
+	return lookup.Closest(), addrs
+}
+
+// Announces the node has chunks of the file to the nodes of the DHT closest to it
+func (n *Node) announceToDHT(fileHash [20]byte) {
+	closest, _ := n.lookupDHT(fileHash, false)
+
+	packet := protocol.NewDHTAnnouncePacket(n.dht.id, fileHash)
+	for _, contact := range closest {
+		addr, err := net.ResolveUDPAddr("udp4", contact.Addr)
+		if err != nil {
+			continue
+		}
+
+		n.srv.SendPacket(&packet, addr)
+	}
+}
+
+// Looks up the peers of the file in the DHT and asks them for the information about it.
+// The download starts with the first answer, and the other peers are added to it as they answer.
+func (n *Node) requestFromDHT(fileHash [20]byte) {
+	_, peers := n.lookupDHT(fileHash, true)
+	if len(peers) == 0 {
+		logger.Warn("No peers of file %x found in the DHT", fileHash)
+		return
+	}
+
+	logger.Info("Found %d peers of file %x in the DHT", len(peers), fileHash)
+
+	packet := protocol.NewRequestFileInfoPacket(fileHash)
+	for _, peer := range peers {
+		addr, err := net.ResolveUDPAddr("udp4", peer)
+		if err != nil {
+			logger.Warn("Invalid peer address %s: %v", peer, err)
+			continue
+		}
+
+		n.dht.fileInfos.Put(fileInfoQuery{fileHash, addr.String()}, time.Now())
+		n.srv.SendPacket(&packet, addr)
+	}
+}
+
+// Forgets the peers which did not answer with the information about a file, as they are asked again on every refresh
+func (n *Node) expireFileInfoQueries() {
+	n.dht.fileInfos.Lock()
+	defer n.dht.fileInfos.Unlock()
+
+	for query, requested := range n.dht.fileInfos.M {
+		if time.Since(requested) > DHTRefreshInterval {
+			delete(n.dht.fileInfos.M, query)
+		}
+	}
+}
+
+// Sends a query to a node which is not in the routing table yet (e.g. a peer sent by a tracker),
+// so it is added to the table if it answers
+func (n *Node) pingDHT(addr *net.UDPAddr) {
+	if n.dht.table.ContainsAddr(addr.String()) {
+		return
+	}
+
+	packet := protocol.NewDHTFindNodePacket(n.dht.id, n.dht.nextQuery.Add(1), n.dht.id)
+	n.srv.SendPacket(&packet, addr)
+}
+
+// Adds the sender of a DHT packet to the routing table. A new contact is also announced the files the node has,
+// if it is among the closest to them, as it would have been if it had joined the DHT earlier.
+func (n *Node) seenInDHT(id dht.ID, addr *net.UDPAddr) {
+	contact := dht.Contact{ID: id, Addr: addr.String()}
+	if !n.dht.table.Update(contact) {
+		return
+	}
+
+	packet := protocol.NewDHTAnnouncePacket(n.dht.id, [20]byte{})
+	for _, fileHash := range n.announcedFiles() {
+		for _, closest := range n.dht.table.Closest(fileHash, dht.BucketSize) {
+			if closest.ID == id {
+				packet.FileHash = fileHash
+				n.srv.SendPacket(&packet, addr)
+				break
+			}
+		}
+	}
+}
+
+// Converts the contacts sent by another node, leaving out the node itself
+func (n *Node) fromDHTContacts(contacts []protocol.DHTContact) []dht.Contact {
+	converted := make([]dht.Contact, 0, len(contacts))
+	for _, contact := range contacts {
+		if contact.ID != n.dht.id {
+			converted = append(converted, dht.Contact{ID: contact.ID, Addr: contact.Addr})
+		}
+	}
+
+	return converted
+}
+
+func toDHTContacts(contacts []dht.Contact) []protocol.DHTContact {
+	converted := make([]protocol.DHTContact, 0, len(contacts))
+	for _, contact := range contacts {
+		converted = append(converted, protocol.DHTContact{ID: contact.ID, Addr: contact.Addr})
+	}
+
+	return converted
+}
+
+// Handler for when a node of the DHT asks for the contacts closest to a target
+func (n *Node) handleDHTFindNodePacket(packet *protocol.DHTFindNodePacket, addr *net.UDPAddr) {
+	n.seenInDHT(packet.SenderID, addr)
+
+	nodes := toDHTContacts(n.dht.table.Closest(packet.Target, dht.BucketSize))
+	answer := protocol.NewDHTNodesPacket(n.dht.id, packet.RequestID, nodes)
+	n.srv.SendPacket(&answer, addr)
+}
+
+// Handler for when a node of the DHT asks for the peers of a file
+func (n *Node) handleDHTFindPeersPacket(packet *protocol.DHTFindPeersPacket, addr *net.UDPAddr) {
+	n.seenInDHT(packet.SenderID, addr)
+
+	peers := n.dht.peers.Get(packet.FileHash)
+	nodes := toDHTContacts(n.dht.table.Closest(packet.FileHash, dht.BucketSize))
+	answer := protocol.NewDHTPeersPacket(n.dht.id, packet.RequestID, peers, nodes)
+	n.srv.SendPacket(&answer, addr)
+}
+
+// Handler for when a node of the DHT answers a query. Answers to queries which timed out are ignored.
+func (n *Node) handleDHTAnswer(senderID dht.ID, requestID uint32, packet protocol.Packet, addr *net.UDPAddr) {
+	n.seenInDHT(senderID, addr)
+
+	answer, ok := n.dht.queries.Get(requestID)
+	if !ok {
+		return
+	}
+
+	select {
+	case answer <- packet:
+	default:
+	}
+}
+
+// Handler for when a peer announces it has chunks of a file
+func (n *Node) handleDHTAnnouncePacket(packet *protocol.DHTAnnouncePacket, addr *net.UDPAddr) {
+	n.seenInDHT(packet.SenderID, addr)
+	n.dht.peers.Add(packet.FileHash, addr.String())
+}
+
+// Handler for when a node, which found this one in the DHT, asks for the information about a file
+func (n *Node) handleRequestFileInfoPacket(packet *protocol.RequestFileInfoPacket, addr *net.UDPAddr) {
+	var info *protocol.PublishFilePacket
+	var bitfield protocol.Bitfield
+
+	if file, ok := n.published.Get(packet.FileHash); ok && file.PublishPacket != nil {
+		info = file.PublishPacket
+
+		chunks := make([]bool, utils.NumberOfChunks(info.FileSize))
+		for i := range chunks {
+			chunks[i] = true
+		}
+		bitfield = protocol.EncodeBitField(chunks)
+	} else if file, ok := n.forDownload.Get(packet.FileHash); ok && file.UpdatedByTracker {
+		info = file.PublishPacket()
+		bitfield = n.updateChunksPacket(file).Bitfield
+	} else {
+		return
+	}
+
+	answer := protocol.NewFileInfoPacket(info.FileName, info.FileSize, info.FileHash, info.HashAlgorithm, info.HashMode, info.ChunkHashes, info.MerkleRoot, info.Files, bitfield)
+	n.srv.SendPacket(&answer, addr)
+}
+
+// Handler for when a peer found in the DHT sends the information about a file and the chunks it has
+func (n *Node) handleFileInfoPacket(packet *protocol.FileInfoPacket, addr *net.UDPAddr) {
+	query := fileInfoQuery{packet.FileHash, addr.String()}
+	if !n.dht.fileInfos.Contains(query) {
+		logger.Warn("Node %s sent the information of file %s, which was not requested from it", addr, packet.FileName)
+		return
+	}
+	n.dht.fileInfos.Delete(query)
+
+	// The information is the same the tracker would send, without any nodes
+	answer := protocol.NewAnswerFileWithNodesPacket(packet.FileName, packet.FileSize, packet.FileHash, packet.HashAlgorithm, packet.HashMode, packet.ChunkHashes, packet.MerkleRoot, packet.Files, nil, nil, nil)
+	forDownloadFile, err := n.startDownload(&answer)
+	if err != nil {
+		logger.Error("Can not download file %s: %v", packet.FileName, err)
+		return
+	}
+	n.dht.wanted.Delete(packet.FileHash)
+
+	forDownloadFile.UpsertNode(addr, packet.Bitfield)
+	logger.Info("Node %s, found in the DHT, has chunks of file %s", addr, packet.FileName)
+}
@@ -2,19 +2,24 @@ package main
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/multifile"
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/transport"
 	"PessiTorrent/internal/utils"
 	"errors"
+	"fmt"
 	"io"
 	"net"
-	"os"
 	"strconv"
 	"time"
 )
 
 func (n *Node) HandlePackets(packet protocol.Packet, conn *transport.TCPConnection) {
 	switch packet := packet.(type) {
+	case *protocol.ChallengePacket:
+		n.handleChallengePacket(packet, conn)
+	case *protocol.AuthenticatedPacket:
+		n.handleAuthenticatedPacket(packet, conn)
 	case *protocol.AnswerFileWithNodesPacket:
 		n.handleAnswerFileWithNodesPacket(packet, conn)
 	case *protocol.AnswerNodesPacket:
@@ -23,8 +28,24 @@ func (n *Node) HandlePackets(packet protocol.Packet, conn *transport.TCPConnecti
 		n.handleFileSuccessPacket(packet, conn)
 	case *protocol.AlreadyExistsPacket:
 		n.handleAlreadyExistsPacket(packet, conn)
+	case *protocol.ConflictPacket:
+		n.handleConflictPacket(packet, conn)
 	case *protocol.NotFoundPacket:
 		n.handleNotFoundPacket(packet, conn)
+	case *protocol.PermissionDeniedPacket:
+		n.handlePermissionDeniedPacket(packet, conn)
+	case *protocol.AmbiguousFilePacket:
+		n.handleAmbiguousFilePacket(packet, conn)
+	case *protocol.SearchResultsPacket:
+		n.handleSearchResultsPacket(packet, conn)
+	case *protocol.FileListPacket:
+		n.handleFileListPacket(packet, conn)
+	case *protocol.PeersPacket:
+		n.handlePeersPacket(packet, conn)
+	case *protocol.HeartbeatAckPacket:
+		n.handleHeartbeatAckPacket(packet, conn)
+	case *protocol.SwarmDeltaPacket:
+		n.handleSwarmDeltaPacket(packet, conn)
 	default:
 		logger.Warn("Unknown packet type: %v.", packet)
 	}
@@ -36,28 +57,78 @@ func (n *Node) HandleUDPPackets(packet protocol.Packet, addr *net.UDPAddr) {
 		n.handleChunkPacket(data, addr)
 	case *protocol.RequestChunksPacket:
 		n.handleRequestChunksPacket(data, addr)
+	case *protocol.DHTFindNodePacket:
+		n.handleDHTFindNodePacket(data, addr)
+	case *protocol.DHTNodesPacket:
+		n.handleDHTAnswer(data.SenderID, data.RequestID, data, addr)
+	case *protocol.DHTFindPeersPacket:
+		n.handleDHTFindPeersPacket(data, addr)
+	case *protocol.DHTPeersPacket:
+		n.handleDHTAnswer(data.SenderID, data.RequestID, data, addr)
+	case *protocol.DHTAnnouncePacket:
+		n.handleDHTAnnouncePacket(data, addr)
+	case *protocol.RequestFileInfoPacket:
+		n.handleRequestFileInfoPacket(data, addr)
+	case *protocol.FileInfoPacket:
+		n.handleFileInfoPacket(data, addr)
+	case *protocol.PeerExchangePacket:
+		n.handlePeerExchangePacket(data, addr)
+	case *protocol.HavePacket:
+		n.handleHavePacket(data, addr)
+	case *protocol.ChokePacket:
+		n.handleChokePacket(data, addr)
+	case *protocol.UnchokePacket:
+		n.handleUnchokePacket(data, addr)
 	default:
 		logger.Warn("Unknown packet type: %v.", data)
 	}
 }
 
-// Handler for when a node requests, to the tracker, a file
-func (n *Node) handleAnswerFileWithNodesPacket(packet *protocol.AnswerFileWithNodesPacket, conn *transport.TCPConnection) {
-	// Update file in forDownload data structure
-	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
-	if !ok {
-		return // File was removed from forDownload files
+// Handler for when the tracker answers a heartbeat
+func (n *Node) handleHeartbeatAckPacket(_ *protocol.HeartbeatAckPacket, conn *transport.TCPConnection) {
+	if tracker := n.trackerOf(conn); tracker != nil {
+		tracker.lastHeartbeatAck.Store(time.Now().UnixNano())
 	}
+}
 
-	logger.Info("Updating nodes who have chunks for file %s", packet.FileName)
+// Handler for when the tracker asks the node to prove it knows its pre-shared key
+func (n *Node) handleChallengePacket(packet *protocol.ChallengePacket, conn *transport.TCPConnection) {
+	logger.Info("Authenticating with the tracker")
+
+	mac := utils.ChallengeMAC(n.key, packet.Nonce, n.getName())
+	responsePacket := protocol.NewChallengeResponsePacket(mac)
+	conn.EnqueuePacket(&responsePacket)
+}
+
+// Handler for when the tracker allows the node to publish and remove files
+func (n *Node) handleAuthenticatedPacket(_ *protocol.AuthenticatedPacket, conn *transport.TCPConnection) {
+	tracker := n.trackerOf(conn)
+	if tracker == nil {
+		return
+	}
+
+	tracker.authenticated.Store(true)
+	logger.Info("Authenticated with the tracker on %s as %s", tracker.addr, n.getName())
+
+	n.reannounce(tracker)
+}
 
-	err := forDownloadFile.SetData(packet.FileHash, packet.ChunkHashes, packet.FileSize, uint16(len(packet.ChunkHashes)), n.downloadDirectory)
+// Handler for when a node requests, to the tracker, a file
+func (n *Node) handleAnswerFileWithNodesPacket(packet *protocol.AnswerFileWithNodesPacket, conn *transport.TCPConnection) {
+	forDownloadFile, err := n.startDownload(packet)
 	if err != nil {
-		logger.Error("Error setting data for file %s: %v", packet.FileName, err)
+		logger.Error("Can not download file %s: %v", packet.FileName, err)
 		return
 	}
-	forDownloadFile.DownloadStarted = time.Now()
-	forDownloadFile.UpdatedByTracker = true
+
+	// The tracker pushes the chunks other nodes get from now on. Trackers which have not
+	// authenticated the node yet are subscribed to once they do
+	if tracker := n.trackerOf(conn); tracker != nil && tracker.authenticated.Load() {
+		subscribePacket := protocol.NewSubscribeFilePacket(packet.FileHash)
+		conn.EnqueuePacket(&subscribePacket)
+	}
+
+	logger.Info("Updating nodes who have chunks for file %s", packet.FileName)
 
 	for _, node := range packet.Nodes {
 		ipAddrStr, err := n.dns.ResolveIP(node.Name)
@@ -78,25 +149,78 @@ func (n *Node) handleAnswerFileWithNodesPacket(packet *protocol.AnswerFileWithNo
 			Port: int(node.Port),
 		}
 
-		localIpAddr := utils.TCPAddrToBytes(n.conn.LocalAddr())
+		localIpAddr := utils.TCPAddrToBytes(conn.LocalAddr())
 
 		if n.udpPort != node.Port || localIpAddr != [4]byte(ipAddr.IP) { // Do not add itself to the list of nodes
 			forDownloadFile.UpsertNode(&udpAddr, node.Bitfield)
+			n.pingDHT(&udpAddr)
 		}
 	}
 
 	logger.Info("File %s information internally updated.", packet.FileName)
 }
 
+// Returns the download of the file, starting it with the data sent by the tracker if it does not exist yet.
+// Every tracker answers the request, so the downloads are locked to start the download only once.
+func (n *Node) startDownload(packet *protocol.AnswerFileWithNodesPacket) (*ForDownloadFile, error) {
+	n.forDownload.Lock()
+	defer n.forDownload.Unlock()
+
+	// A download of the file may already exist (e.g. if it was resumed or another tracker answered first)
+	if forDownloadFile, ok := n.forDownload.M[packet.FileHash]; ok {
+		return forDownloadFile, nil
+	}
+
+	if n.published.Contains(packet.FileHash) {
+		return nil, errors.New("file is already downloaded")
+	}
+
+	forDownloadFile := NewForDownloadFile(packet.FileName, packet.FileHash)
+
+	hasher, err := utils.HasherFromAlgorithm(packet.HashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	numberOfChunks := utils.NumberOfChunks(packet.FileSize)
+
+	var tree *utils.MerkleTree
+	switch {
+	case packet.HashMode == protocol.HashModeMerkle:
+		tree = utils.NewPartialMerkleTree(hasher, packet.MerkleRoot, int(numberOfChunks))
+	case uint64(len(packet.ChunkHashes)) != numberOfChunks:
+		return nil, fmt.Errorf("file has %d chunk hashes instead of %d", len(packet.ChunkHashes), numberOfChunks)
+	}
+
+	err = forDownloadFile.SetData(hasher, packet.ChunkHashes, tree, packet.FileSize, uint16(numberOfChunks), packet.Files, n.downloadDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("error setting data: %v", err)
+	}
+
+	err = forDownloadFile.SaveState()
+	if err != nil {
+		logger.Error("Error saving download state of file %s: %v", packet.FileName, err)
+	}
+	forDownloadFile.DownloadStarted = time.Now()
+	forDownloadFile.UpdatedByTracker = true
+	forDownloadFile.OnChunkDownloaded = func(chunkIndex uint16) {
+		n.announceChunk(forDownloadFile, chunkIndex)
+	}
+
+	n.forDownload.M[packet.FileHash] = forDownloadFile
+
+	return forDownloadFile, nil
+}
+
 // Handler for when a node request, to the tracker, updated information about nodes who have a file
 func (n *Node) handleAnswerNodesPacket(packet *protocol.AnswerNodesPacket, conn *transport.TCPConnection) {
 	// Update file in forDownload data structure
-	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	forDownloadFile, ok := n.forDownload.Get(packet.FileHash)
 	if !ok {
 		return // File was removed from forDownload files
 	}
 
-	logger.Info("Updating nodes who have chunks for file %s", packet.FileName)
+	logger.Info("Updating nodes who have chunks for file %s", forDownloadFile.FileName)
 
 	for _, node := range packet.Nodes {
 		ipAddrStr, _ := n.dns.ResolveIP(node.Name)
@@ -110,14 +234,48 @@ func (n *Node) handleAnswerNodesPacket(packet *protocol.AnswerNodesPacket, conn
 			IP:   ipAddr.IP,
 			Port: int(node.Port),
 		}
-		localIpAddr := utils.TCPAddrToBytes(n.conn.LocalAddr())
+		localIpAddr := utils.TCPAddrToBytes(conn.LocalAddr())
 
 		if n.udpPort != node.Port || localIpAddr != [4]byte(ipAddr.IP) { // Do not add itself to the list of nodes
 			forDownloadFile.UpsertNode(&udpAddr, node.Bitfield)
+			n.pingDHT(&udpAddr)
 		}
 	}
 
-	logger.Info("File %s information internally updated.", packet.FileName)
+	logger.Info("File %s information internally updated.", forDownloadFile.FileName)
+}
+
+// Handler for when the tracker pushes the chunks another node got of a file being downloaded
+func (n *Node) handleSwarmDeltaPacket(packet *protocol.SwarmDeltaPacket, conn *transport.TCPConnection) {
+	forDownloadFile, ok := n.forDownload.Get(packet.FileHash)
+	if !ok || !forDownloadFile.UpdatedByTracker {
+		return
+	}
+
+	ipAddrStr, err := n.dns.ResolveIP(packet.Name)
+	if err != nil {
+		logger.Error("Error resolving dns ip address on %s: %v", packet.Name, err)
+		return
+	}
+
+	ipAddr, err := net.ResolveUDPAddr("udp", ipAddrStr+":"+strconv.Itoa(int(packet.Port)))
+	if err != nil {
+		logger.Error("Error resolving domain %s: %v", packet.Name, err)
+		return
+	}
+
+	udpAddr := net.UDPAddr{
+		IP:   ipAddr.IP,
+		Port: int(packet.Port),
+	}
+
+	localIpAddr := utils.TCPAddrToBytes(conn.LocalAddr())
+	if n.udpPort == packet.Port && localIpAddr == [4]byte(ipAddr.IP) { // Do not add itself to the list of nodes
+		return
+	}
+
+	forDownloadFile.AddNodeChunks(&udpAddr, packet.Chunks)
+	n.pingDHT(&udpAddr)
 }
 
 // Handler for when a node publishes/removes a file in/from the network
@@ -127,57 +285,147 @@ func (n *Node) handleFileSuccessPacket(packet *protocol.FileSuccessPacket, conn
 		logger.Info("File %s published in the network successfully", packet.FileName)
 
 		// Remove file from pending and add it to published, since tracker has accepted it
-		file, _ := n.pending.Get(packet.FileName)
-		n.published.Put(packet.FileName, file)
-		n.pending.Delete(packet.FileName)
+		file, ok := n.pending.Get(packet.FileHash)
+		if ok {
+			n.published.Put(packet.FileHash, file)
+			n.pending.Delete(packet.FileHash)
+		}
+
+		n.recordPublishResult(packet.FileHash, Published)
+		go n.announceToDHT(packet.FileHash)
 	case protocol.RemoveFileType:
-		logger.Info("File %s removed from the network successfully", packet.FileName)
+		logger.Info("File %s removed successfully", packet.FileName)
 
-		// Remove file from published, since tracker has removed it from the network
-		n.published.Delete(packet.FileName)
+		// Remove file from published, since tracker no longer lists the node as a seeder
+		n.published.Delete(packet.FileHash)
 	default:
 		logger.Warn("Unknown file success packet type: %v", packet.Type)
 	}
 }
 
-// Handler for when the file, the node is trying to publish, already exists in the network
+// Handler for when the file, the node is trying to publish, is already being seeded by it
 func (n *Node) handleAlreadyExistsPacket(packet *protocol.AlreadyExistsPacket, conn *transport.TCPConnection) {
-	logger.Info("File %s already exists in the network", packet.Filename)
+	logger.Info("File %s is already published by this node", packet.Filename)
+
+	// The file may still be pending if this tracker answered before the others (or it kept the node's bitfields)
+	file, ok := n.pending.Get(packet.FileHash)
+	if ok {
+		n.published.Put(packet.FileHash, file)
+		n.pending.Delete(packet.FileHash)
+	}
+
+	n.recordPublishResult(packet.FileHash, Duplicate)
+}
+
+// Handler for when a different file with the same hash, as the one the node is trying to publish, exists in the network
+func (n *Node) handleConflictPacket(packet *protocol.ConflictPacket, conn *transport.TCPConnection) {
+	logger.Info("File %s conflicts with a different file with the same hash (%x) in the network", packet.FileName, packet.FileHash)
 
 	// Remove file from pending, since tracker has rejected it
-	n.pending.Delete(packet.Filename)
+	n.pending.Delete(packet.FileHash)
+
+	n.recordPublishResult(packet.FileHash, Failed)
 }
 
 // Handler for when the file, the node is trying to download, does not exist in the network
 func (n *Node) handleNotFoundPacket(packet *protocol.NotFoundPacket, conn *transport.TCPConnection) {
-	logger.Info("File %s was not found in the network", packet.Filename)
+	if packet.FileHash == [20]byte{} {
+		logger.Info("File %s was not found in the network", packet.Filename)
+	} else {
+		logger.Info("File %x was not found in the network", packet.FileHash)
+	}
+}
+
+// Handler for when the node tries to remove a file it did not publish and does not seed
+func (n *Node) handlePermissionDeniedPacket(packet *protocol.PermissionDeniedPacket, conn *transport.TCPConnection) {
+	logger.Info("Not allowed to remove file %s, since it was published by another node", packet.Filename)
+}
 
-	// Remove file from downloading, since it does not exist
-	n.forDownload.Delete(packet.Filename)
+// Handler for when the file name, the node is trying to download, is shared by more than one file
+func (n *Node) handleAmbiguousFilePacket(packet *protocol.AmbiguousFilePacket, conn *transport.TCPConnection) {
+	logger.Info("There are %d files named %s in the network. Request one of them by hash:", len(packet.FileHashes), packet.FileName)
+	for _, fileHash := range packet.FileHashes {
+		logger.Info("\t%x", fileHash)
+	}
+}
+
+// Handler for when the tracker answers a search
+func (n *Node) handleSearchResultsPacket(packet *protocol.SearchResultsPacket, conn *transport.TCPConnection) {
+	if len(packet.Results) == 0 {
+		logger.Info("No files match %s", packet.Pattern)
+		return
+	}
+
+	logger.Info("Files matching %s:", packet.Pattern)
+	for _, result := range packet.Results {
+		logger.Info("\t%s (%x) with size %d, %d seeders and %d leechers", result.FileName, result.FileHash, result.FileSize, result.Seeders, result.Leechers)
+	}
+}
+
+// Handler for when the tracker answers with every file it tracks
+func (n *Node) handleFileListPacket(packet *protocol.FileListPacket, conn *transport.TCPConnection) {
+	if len(packet.Files) == 0 {
+		logger.Info("The tracker has no files")
+		return
+	}
+
+	logger.Info("Files in the tracker:")
+	for _, file := range packet.Files {
+		// Availability is the average number of copies of each chunk among the connected nodes
+		availability := 0.0
+		if file.NumberOfChunks != 0 {
+			availability = float64(file.ChunkCopies) / float64(file.NumberOfChunks)
+		}
+
+		logger.Info("\t%s (%x) with size %d, %d chunks and availability %.2f", file.FileName, file.FileHash, file.FileSize, file.NumberOfChunks, availability)
+	}
+}
+
+// Handler for when the tracker answers with the nodes which have chunks of a file
+func (n *Node) handlePeersPacket(packet *protocol.PeersPacket, conn *transport.TCPConnection) {
+	if len(packet.Nodes) == 0 {
+		logger.Info("No nodes have chunks of file %s (%x)", packet.FileName, packet.FileHash)
+		return
+	}
+
+	logger.Info("Nodes with chunks of file %s (%x):", packet.FileName, packet.FileHash)
+	for _, node := range packet.Nodes {
+		chunks := protocol.CountSetBits(node.Bitfield, int(packet.NumberOfChunks))
+
+		completion := 100.0
+		if packet.NumberOfChunks != 0 {
+			completion = float64(chunks) / float64(packet.NumberOfChunks) * 100
+		}
+
+		logger.Info("\t%s:%d with %d/%d chunks (%.2f%%)", node.Name, node.Port, chunks, packet.NumberOfChunks, completion)
+	}
 }
 
 func (n *Node) handleChunkPacket(packet *protocol.ChunkPacket, addr *net.UDPAddr) {
 	// Check if hash of chunk is correct
-	forDownloadFile, ok := n.forDownload.Get(packet.FileName)
+	forDownloadFile, ok := n.forDownload.Get(packet.FileHash)
 	if !ok {
-		logger.Warn("File %s not found in forDownload files", packet.FileName)
+		logger.Warn("File %x not found in forDownload files", packet.FileHash)
 		return
 	}
 
+	// Answered requests grow the congestion window of the node
+	n.congestionController(addr.String()).Acked(ChunkRequest{packet.FileHash, packet.Chunk})
+
 	// Discard packet if chunk is already downloaded
 	if forDownloadFile.ChunkAlreadyDownloaded(packet.Chunk) {
 		return
 	}
 
-	// Discard packet if hash of chunk is not correct
-	if forDownloadFile.GetChunkHash(packet.Chunk) != utils.HashChunk(packet.ChunkContent) {
-		logger.Warn("Received incorrect hash of chunk %d of file %s", packet.Chunk, packet.FileName)
+	// Discard packet if hash (or merkle proof) of chunk is not correct
+	if !forDownloadFile.VerifyChunk(packet.Chunk, packet.ChunkContent, packet.Proof) {
+		logger.Warn("Received incorrect hash of chunk %d of file %s", packet.Chunk, forDownloadFile.FileName)
 		return
 	}
 
 	nodeInfo, ok := forDownloadFile.Nodes.Get(addr.String())
 	if !ok {
-		logger.Warn("Node %s sent unrequested chunk from file %s", addr, packet.FileName)
+		logger.Warn("Node %s sent unrequested chunk from file %s", addr, forDownloadFile.FileName)
 	} else {
 		requested, b := nodeInfo.GetLastTimeChunkWasRequested(packet.Chunk)
 		if b && requested != (time.Time{}) {
@@ -192,68 +440,78 @@ func (n *Node) handleChunkPacket(packet *protocol.ChunkPacket, addr *net.UDPAddr
 	const AnouncePercentageInterval = 10
 
 	if int(newPercentage/AnouncePercentageInterval) != int(percentage/AnouncePercentageInterval) {
-		logger.Info("File %s download progress: (%.1f%%)", packet.FileName, newPercentage)
+		logger.Info("File %s download progress: (%.1f%%)", forDownloadFile.FileName, newPercentage)
 	}
 
 	// Write chunk to file
+	forDownloadFile.PendingChunks.Delete(packet.Chunk)
 	forDownloadFile.WriteChunkToDisk(packet.Chunk, packet.ChunkContent)
 }
 
 func (n *Node) handleRequestChunksPacket(packet *protocol.RequestChunksPacket, addr *net.UDPAddr) {
 	logger.Info("Request chunks packet received from %s", addr)
 
-	// Get file from published files
-	publishedFile, ok := n.published.Get(packet.FileName)
+	// Get file from published files, or from the ones being downloaded
+	file, ok := n.published.Get(packet.FileHash)
 	if !ok {
-		logger.Warn("File %s not found in published files", packet.FileName)
-
-		downloadFile, ok := n.forDownload.Get(packet.FileName)
+		downloadFile, ok := n.forDownload.Get(packet.FileHash)
 		if !ok {
-			logger.Warn("File %s not found in forDownload files", packet.FileName)
+			logger.Warn("File %x not found in published or forDownload files", packet.FileHash)
 			return
 		}
 
-		file := NewFile(packet.FileName, downloadFile.FilePath)
-		n.sendFileChunks(&file, packet, addr)
+		newFile := NewFile(downloadFile.FileName, downloadFile.FileHash, downloadFile.FilePath, downloadFile.Files, downloadFile.Tree)
+		file = &newFile
+	}
 
+	// Choked peers are told they will not be sent chunks, so they stop requesting them.
+	// Only requests which can be served take an upload slot
+	if !n.uploads.Allow(addr.String()) {
+		packet := protocol.NewChokePacket()
+		n.srv.SendPacket(&packet, addr)
 		return
 	}
 
-	n.sendFileChunks(publishedFile, packet, addr)
+	n.enqueueUpload(file, packet, addr)
 }
 
 func (n *Node) sendFileChunks(publishedFile *File, packet *protocol.RequestChunksPacket, addr *net.UDPAddr) {
-	// Open file by the given path
-	file, err := os.Open(publishedFile.Path)
+	// Open file (or every file of the directory) by the given path
+	file, err := multifile.Open(publishedFile.Path, publishedFile.Files)
 	if err != nil {
 		logger.Warn("Error opening file: %v", err)
 		return
 	}
+	defer file.Close()
 
-	stats, _ := file.Stat()
-	chunkSize := utils.ChunkSize(uint64(stats.Size()))
+	chunkSize := utils.ChunkSize(file.Size())
 
 	// Send requested chunks
 	for _, chunk := range packet.Chunks {
-		logger.Info("Sending chunk %d of file %s to %s", chunk, packet.FileName, addr)
-
-		// Seek to the beginning of the chunk
-		_, err = file.Seek(int64(uint64(chunk)*chunkSize), 0)
-		if err != nil {
-			logger.Warn("Error seeking file: %v", err)
-			return
-		}
+		logger.Info("Sending chunk %d of file %s to %s", chunk, publishedFile.FileName, addr)
 
 		// Read chunk bytes
 		chunkContent := make([]byte, chunkSize)
-		read, err := file.Read(chunkContent)
+		read, err := file.ReadAt(chunkContent, int64(uint64(chunk)*chunkSize))
 		if err != nil && !errors.Is(err, io.EOF) {
 			logger.Warn("Error reading file: %v", err)
 			return
 		}
 
-		// Send chunk bytes
-		packet := protocol.NewChunkPacket(packet.FileName, chunk, chunkContent[:read])
+		// In merkle hash mode, the proof of the chunk is sent along with it
+		var proof [][]byte
+		if publishedFile.Tree != nil {
+			var ok bool
+			proof, ok = publishedFile.Tree.Proof(int(chunk))
+			if !ok {
+				logger.Warn("No merkle proof of chunk %d of file %s", chunk, publishedFile.FileName)
+				continue
+			}
+		}
+
+		// Send chunk bytes, as fast as the upload rate limit allows
+		n.uploadLimit.Wait(uint64(read))
+		packet := protocol.NewChunkPacket(packet.FileHash, chunk, chunkContent[:read], proof)
 		n.srv.SendPacket(&packet, addr)
 		n.nodeStatistics.addUploadedBytes(chunkSize)
 	}
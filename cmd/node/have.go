@@ -0,0 +1,33 @@
+package main
+
+import (
+	"PessiTorrent/internal/protocol"
+	"net"
+)
+
+// Tells the other nodes of the file about a chunk just downloaded, so they can request it
+// without waiting for the tracker to know about it
+func (n *Node) announceChunk(file *ForDownloadFile, chunkIndex uint16) {
+	packet := protocol.NewHavePacket(file.FileHash, chunkIndex)
+
+	for _, address := range file.Nodes.Keys() {
+		addr, err := net.ResolveUDPAddr("udp4", address)
+		if err != nil {
+			continue
+		}
+
+		n.srv.SendPacket(&packet, addr)
+	}
+}
+
+// Handler for when another node of a file being downloaded gets a new chunk of it
+func (n *Node) handleHavePacket(packet *protocol.HavePacket, addr *net.UDPAddr) {
+	file, ok := n.forDownload.Get(packet.FileHash)
+	// Only nodes already known from the tracker, the DHT or PEX can update their chunks,
+	// so any address can not join the swarm by sending HAVE packets
+	if !ok || !file.UpdatedByTracker || !file.Nodes.Contains(addr.String()) {
+		return
+	}
+
+	file.AddNodeChunks(addr, []uint16{packet.Chunk})
+}
@@ -3,8 +3,11 @@ package main
 import (
 	"PessiTorrent/internal/config"
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/utils"
 	"flag"
 	"strconv"
+	"strings"
 )
 
 func main() {
@@ -15,13 +18,71 @@ func main() {
 	}
 
 	dns := cfg.DNS.Host + ":" + strconv.FormatUint(uint64(cfg.DNS.Port), 10)
-	trackerAddr := cfg.Tracker.Host + ":" + strconv.Itoa(int(cfg.Tracker.Port))
+	trackerAddrs := cfg.Node.Trackers
+	if len(trackerAddrs) == 0 {
+		trackerAddrs = []string{cfg.Tracker.Host + ":" + strconv.Itoa(int(cfg.Tracker.Port))}
+	}
 	udpPort := cfg.Node.Port
 
-	flag.StringVar(&trackerAddr, "t", trackerAddr, "Tracker address")
+	trackers := strings.Join(trackerAddrs, ",")
+	flag.StringVar(&trackers, "t", trackers, "Tracker addresses, separated by commas")
 	flag.UintVar(&udpPort, "p", udpPort, "Node UDP port")
+
+	bootstrap := strings.Join(cfg.Node.DHTBootstrap, ",")
+	flag.StringVar(&bootstrap, "b", bootstrap, "UDP addresses of the nodes used to join the DHT, separated by commas")
 	flag.Parse()
 
-	node := NewNode(trackerAddr, uint16(udpPort), dns)
+	var dhtBootstrap []string
+	if bootstrap != "" {
+		dhtBootstrap = strings.Split(bootstrap, ",")
+	}
+
+	var hashMode uint8
+	switch cfg.Node.HashMode {
+	case "", "flat":
+		hashMode = protocol.HashModeFlat
+	case "merkle":
+		hashMode = protocol.HashModeMerkle
+	default:
+		logger.Error("Unknown hash mode: %s", cfg.Node.HashMode)
+		return
+	}
+
+	hashAlgorithm := cfg.Node.HashAlgorithm
+	if hashAlgorithm == "" {
+		hashAlgorithm = "sha1"
+	}
+
+	hasher, err := utils.HasherFromName(hashAlgorithm)
+	if err != nil {
+		logger.Error("%s", err)
+		return
+	}
+
+	uploadLimit, err := parseLimit(cfg.Node.UploadLimit)
+	if err != nil {
+		logger.Error("Invalid upload limit: %s", err)
+		return
+	}
+
+	downloadLimit, err := parseLimit(cfg.Node.DownloadLimit)
+	if err != nil {
+		logger.Error("Invalid download limit: %s", err)
+		return
+	}
+
+	node := NewNode(strings.Split(trackers, ","), dhtBootstrap, uint16(udpPort), dns, cfg.Node.Key, hashMode, hasher)
+	node.uploadLimit.SetRate(uploadLimit)
+	node.downloadLimit.SetRate(downloadLimit)
+
 	node.Start()
 }
+
+// Returns the rate of a limit in the config, where an empty one means no limit
+func parseLimit(limit string) (uint64, error) {
+	if limit == "" {
+		return 0, nil
+	}
+
+	return utils.ParseRate(limit)
+}
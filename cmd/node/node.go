@@ -11,93 +11,141 @@ import (
 	"PessiTorrent/internal/utils"
 	"net"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	UpdateServerChunksInterval  = 5 * time.Second
-	MaxChunksPerRequest         = 100
-	ChunkRequestTimeoutDuration = 500 * time.Millisecond
-	MaxTriesPerChunk            = 3
-	MaxNodeTimeouts             = 3
-	TickInterval                = 100 * time.Millisecond
-	DefaultDownloadDirectory    = "downloads"
+	UpdateServerChunksInterval = 5 * time.Second
+	UpdateNodesInterval        = 1 * time.Minute // The tracker pushes the new chunks of other nodes, so every node is only asked for rarely
+	MaxChunksPerRequest        = 100
+	MaxTriesPerChunk           = 3
+	MaxNodeTimeouts            = 3
+	TickInterval               = 100 * time.Millisecond
+	DefaultDownloadDirectory   = "downloads"
+	HashingBufferSize          = 1 << 20   // Size of the buffer used to read files while hashing them
+	HashingProgressThreshold   = 100 << 20 // Files smaller than this are hashed without announcing the progress
+	HeartbeatInterval          = 10 * time.Second
+	ReconnectInitialBackoff    = 1 * time.Second
+	ReconnectMaxBackoff        = 1 * time.Minute
+	TrackerTimeout             = 3 * HeartbeatInterval // Time without heartbeat acks after which a tracker is considered silent
 )
 
 type Node struct {
 	dns *dns.DNS
 
-	trackerAddr string
-	udpPort     uint16
-	connected   bool // Whether the node is connected to the tracker or not
+	trackers structures.SynchronizedList[*TrackerConnection]
+	udpPort  uint16
+	key      string // Pre-shared key used to authenticate with the trackers
+	hashMode uint8  // How the chunks of the files published by the node are verified
+	hasher   utils.Hasher
 
-	conn transport.TCPConnection
-	srv  transport.UDPServer
-	tck  ticker.Ticker
+	srv transport.UDPServer
+	tck ticker.Ticker
 
-	published      structures.SynchronizedMap[string, *File]
-	pending        structures.SynchronizedMap[string, *File]
-	forDownload    structures.SynchronizedMap[string, *ForDownloadFile]
-	downloadedFile structures.SynchronizedMap[string, *File]
+	heartbeatTck ticker.Ticker
+	pexTck       ticker.Ticker
+	stopping     atomic.Bool // Whether the node is shutting down, so it must not reconnect to the trackers
+
+	dht    *DHTNode
+	dhtTck ticker.Ticker
+
+	published      structures.SynchronizedMap[[20]byte, *File] // File hash -> File
+	pending        structures.SynchronizedMap[[20]byte, *File]
+	forDownload    structures.SynchronizedMap[[20]byte, *ForDownloadFile]
+	downloadedFile structures.SynchronizedMap[[20]byte, *File]
 
 	downloadDirectory string
 
 	nodeStatistics *NodeStatistics
+	peers          structures.SynchronizedMap[string, *transport.CongestionController[ChunkRequest]] // Peer UDP address -> Congestion controller
+
+	uploadLimit   *transport.RateLimiter
+	downloadLimit *transport.RateLimiter
+
+	uploads     *UploadScheduler
+	uploadQueue chan uploadRequest
+	chokedBy    structures.SynchronizedMap[string, time.Time] // Peer UDP address -> Last time it choked this node
+	chokeTck    ticker.Ticker
+
+	name struct {
+		sync.Mutex
+		value string // Name the node announced itself with
+	}
+
+	publishing struct {
+		sync.Mutex
+		summary *PublishSummary // Directory being published with publish-all, if any
+	}
 
 	quitChannel chan struct{}
 }
 
-func NewNode(trackerAddr string, udpPort uint16, dnsAddr string) Node {
+func NewNode(trackerAddrs []string, dhtBootstrap []string, udpPort uint16, dnsAddr string, key string, hashMode uint8, hasher utils.Hasher) Node {
+	trackers := make([]*TrackerConnection, 0, len(trackerAddrs))
+	for _, addr := range trackerAddrs {
+		trackers = append(trackers, NewTrackerConnection(addr))
+	}
+
 	return Node{
 		dns: dns.NewDNS(dnsAddr),
 
-		trackerAddr: trackerAddr,
-		udpPort:     udpPort,
+		trackers: structures.SynchronizedList[*TrackerConnection]{L: trackers},
+		udpPort:  udpPort,
+		key:      key,
+		hashMode: hashMode,
+		hasher:   hasher,
 
-		pending:     structures.NewSynchronizedMap[string, *File](),
-		published:   structures.NewSynchronizedMap[string, *File](),
-		forDownload: structures.NewSynchronizedMap[string, *ForDownloadFile](),
+		dht: NewDHTNode(dhtBootstrap),
+
+		pending:     structures.NewSynchronizedMap[[20]byte, *File](),
+		published:   structures.NewSynchronizedMap[[20]byte, *File](),
+		forDownload: structures.NewSynchronizedMap[[20]byte, *ForDownloadFile](),
 
 		downloadDirectory: DefaultDownloadDirectory,
 
 		nodeStatistics: NewNodeStatistics(),
+		peers:          structures.NewSynchronizedMap[string, *transport.CongestionController[ChunkRequest]](),
+
+		uploadLimit:   transport.NewRateLimiter(0),
+		downloadLimit: transport.NewRateLimiter(0),
+
+		uploads:     NewUploadScheduler(),
+		uploadQueue: make(chan uploadRequest, UploadQueueSize),
+		chokedBy:    structures.NewSynchronizedMap[string, time.Time](),
 
 		quitChannel: make(chan struct{}),
 	}
 }
 
 func (n *Node) Start() {
+	n.resumeDownloads(n.downloadDirectory)
+
+	// The UDP server is started first, since the DHT uses it to join
+	n.startUDP()
+	n.startUploads()
+
 	go n.startTCP()
-	go n.startUDP()
+	go n.startDHT()
 	go n.startCLI()
 	go n.startTicker()
+	go n.startHeartbeat()
+	go n.startPeerExchange()
+	go n.startChoking()
 
 	<-n.quitChannel
 }
 
 func (n *Node) startTCP() {
-	conn, err := net.Dial("tcp4", n.trackerAddr)
-	if err != nil {
-		logger.Error("No tracker to connect found on %s. Try again later with the 'connect' command", n.trackerAddr)
-		return
-	}
-
-	n.connected = true
-	n.conn = transport.NewTCPConnection(conn, n.HandlePackets, n.Stop)
-	go n.conn.Start()
-
-	logger.Info("Connected to tracker on %s", n.trackerAddr)
-
-	// Notify tracker of node's existence
-	ipAddr := utils.TCPAddrToBytes(n.conn.LocalAddr())
-	domain, err := n.dns.ResolveDomain(net.IP(ipAddr[:]).String())
-	if err != nil {
-		logger.Error("Error resolving domain: %v", err)
-		return
-	}
-
-	packet := protocol.NewInitPacket(domain, n.udpPort)
-	n.conn.EnqueuePacket(&packet)
+	n.trackers.ForEach(func(tracker *TrackerConnection) {
+		go func() {
+			err := n.dialTracker(tracker)
+			if err != nil {
+				logger.Error("No tracker to connect found on %s. Try again later with the 'connect' command", tracker.addr)
+			}
+		}()
+	})
 }
 
 func (n *Node) startUDP() {
@@ -124,13 +172,18 @@ func (n *Node) startCLI() {
 	logger.SetLogger(&console)
 
 	c := cli.NewCLI(n.Stop, console)
-	c.AddCommand("connect", "<tracker address>", "Connect to the tracker", 1, n.connect)
+	c.AddCommand("connect", "<tracker address>", "Connect to a tracker", 1, n.connect)
 	c.AddCommand("publish", "<file name | directory>", "", 1, n.publish)
-	c.AddCommand("request", "<file name>", "", 1, n.requestFile)
+	c.AddCommand("publish-all", "<directory>", "Publish every file of a directory as a separate file", 1, n.publishAll)
+	c.AddCommand("request", "<file name | file hash>", "", 1, n.requestFile)
+	c.AddCommand("search", "<pattern>", "Search files in the tracker by name (supports * and ? wildcards)", 1, n.search)
+	c.AddCommand("ls", "", "List the files in the tracker", 0, n.listFiles)
+	c.AddCommand("peers", "<file name | file hash>", "List the nodes with chunks of a file", 1, n.listPeers)
 	c.AddCommand("status", "", "Show the status of the node", 0, n.status)
 	c.AddCommand("statistics", "", "Show the statistics of the node", 0, n.statistics)
 	c.AddCommand("set-downloads", "<directory>", "Set download directory path", 1, n.setDownloadDirectory)
-	c.AddCommand("remove", "<file name>", "", 1, n.removeFile)
+	c.AddCommand("limit", "up|down <rate>", "Limit the upload or download rate (e.g. 1MB/s, 0 for no limit)", 2, n.setLimit)
+	c.AddCommand("remove", "<file name | file hash>", "", 1, n.removeFile)
 	c.Start()
 }
 
@@ -140,7 +193,13 @@ func (n *Node) startTicker() {
 	n.tck = tck
 }
 
-func (n *Node) updateServerChunks(file *ForDownloadFile) {
+func (n *Node) startHeartbeat() {
+	tck := ticker.NewTicker(HeartbeatInterval, n.sendHeartbeats)
+	tck.Start()
+	n.heartbeatTck = tck
+}
+
+func (n *Node) updateChunksPacket(file *ForDownloadFile) protocol.UpdateChunksPacket {
 	bitfield := make([]bool, 0)
 	file.Chunks.ForEach(func(chunkInfo ChunkInfo) {
 		bitfield = append(bitfield, chunkInfo.Downloaded)
@@ -148,40 +207,81 @@ func (n *Node) updateServerChunks(file *ForDownloadFile) {
 
 	encondedBitfield := protocol.EncodeBitField(bitfield)
 
-	packet := protocol.NewUpdateChunksPacket(file.FileName, encondedBitfield)
-	n.conn.EnqueuePacket(&packet)
+	return protocol.NewUpdateChunksPacket(file.FileHash, encondedBitfield)
+}
+
+func (n *Node) updateServerChunks(file *ForDownloadFile) {
+	packet := n.updateChunksPacket(file)
+	n.sendToAuthenticatedTrackers(&packet)
+}
+
+// Seeds a downloaded file once its content matches its hash. Chunks are only verified against the
+// information about the file, which may come from any peer found in the DHT instead of a tracker.
+func (n *Node) finishDownload(file *ForDownloadFile) {
+	err := file.VerifyFileHash()
+	if err != nil {
+		logger.Error("Downloaded file %s does not match its hash, so it is not seeded: %v", file.FileName, err)
+		return
+	}
+
+	if n.isConnected() {
+		n.updateServerChunks(file)
+	}
+
+	newFile := NewFile(file.FileName, file.FileHash, file.FilePath, file.Files, file.Tree)
+	newFile.PublishPacket = file.PublishPacket()
+	n.published.Put(file.FileHash, &newFile)
 }
 
 func (n *Node) tick() {
 	n.forDownload.Lock()
 	defer n.forDownload.Unlock()
 
-	for fileName, file := range n.forDownload.M {
+	n.expireChunkRequests()
+
+	for fileHash, file := range n.forDownload.M {
+		fileName := file.FileName
+
 		if !file.UpdatedByTracker {
 			continue
 		}
 
-		if time.Since(file.LastServerChunksUpdate) > UpdateServerChunksInterval || file.IsFileDownloaded() {
+		if time.Since(file.LastStateSave) > SaveStateInterval && !file.IsFileDownloaded() {
+			err := file.SaveState()
+			if err != nil {
+				logger.Error("Error saving download state of file %s: %v", fileName, err)
+			}
+		}
+
+		// The last chunks are only sent once the whole file is verified
+		if n.isConnected() && time.Since(file.LastServerChunksUpdate) > UpdateServerChunksInterval && !file.IsFileDownloaded() {
 			file.LastServerChunksUpdate = time.Now()
 			n.updateServerChunks(file)
 			logger.Info("Sent update chunks packet to tracker for file %s", fileName)
 
-			if !file.IsFileDownloaded() { // If file is downloaded, we don't need to update the nodes with the file
-				// Also request to update our nodes info about the file
-				packet := protocol.NewUpdateFilePacket(fileName)
-				n.conn.EnqueuePacket(&packet)
+			// Every node is asked for once in a while, since the tracker does not push the nodes which left or are in federated trackers
+			if time.Since(file.LastNodesUpdate) > UpdateNodesInterval {
+				file.LastNodesUpdate = time.Now()
+				packet := protocol.NewUpdateFilePacket(fileHash)
+				n.sendToTrackers(&packet)
 			}
 		}
 
 		if file.IsFileDownloaded() {
 			timeToDownload := time.Since(file.DownloadStarted)
-			logger.Info("File %s was successfully downloaded in %s", fileName, timeToDownload.String())
+			logger.Info("File %s was successfully downloaded to %s in %s", fileName, file.FilePath, timeToDownload.String())
 			file.FileWriter.Stop()
 
-			newFile := NewFile(file.FileName, file.FilePath)
-			n.published.Put(file.FileName, &newFile)
+			err := file.RemoveState()
+			if err != nil {
+				logger.Error("Error removing download state of file %s: %v", fileName, err)
+			}
+
+			unsubscribePacket := protocol.NewUnsubscribeFilePacket(fileHash)
+			n.sendToTrackers(&unsubscribePacket)
 
-			delete(n.forDownload.M, fileName)
+			delete(n.forDownload.M, fileHash)
+			go n.finishDownload(file)
 			continue
 		}
 
@@ -203,9 +303,19 @@ func (n *Node) tick() {
 		chunksToRequest := make(map[*NodeInfo][]uint16)
 
 		for _, nodeInfo := range nodes {
+			if n.isChokedBy(nodeInfo.Address) {
+				continue
+			}
+
 			chunksToRequest[nodeInfo] = make([]uint16, 0)
 
-			for len(missingChunks) > 0 && len(chunksToRequest[nodeInfo]) < MaxChunksPerRequest {
+			// Only request as many chunks as the congestion window of the node allows
+			maxChunks := n.congestionController(nodeInfo.Address).Available()
+			if maxChunks > MaxChunksPerRequest {
+				maxChunks = MaxChunksPerRequest
+			}
+
+			for len(missingChunks) > 0 && len(chunksToRequest[nodeInfo]) < maxChunks {
 				chunk := missingChunks[0]
 				missingChunks = missingChunks[1:] // Pop first element
 
@@ -215,21 +325,29 @@ func (n *Node) tick() {
 				}
 
 				lastRequested, ok := file.PendingChunks.Get(uint16(chunk)) // Check if chunk has already been requested
-				if ok && time.Since(lastRequested) < ChunkRequestTimeoutDuration {
+				if ok && time.Since(lastRequested) < transport.MaxRTO {
 					continue
 				}
 
-				requestInfo.NumberOfTries++
-				if requestInfo.NumberOfTries >= MaxTriesPerChunk {
+				if requestInfo.NumberOfTries+1 >= MaxTriesPerChunk {
+					requestInfo.NumberOfTries++
 					logger.Warn("Node %s is not responding.", nodeInfo.Address)
 					nodeInfo.Timeouts++
 					if nodeInfo.Timeouts >= MaxNodeTimeouts {
 						logger.Warn("Node %s has timed out 3 times. Removing it from file %s", nodeInfo.Address, file.FileName)
 						file.Nodes.Delete(nodeInfo.Address)
 					}
-				} else {
-					chunksToRequest[nodeInfo] = append(chunksToRequest[nodeInfo], uint16(chunk)) // Queue chunk
+					continue
+				}
+
+				// Once the download rate limit is reached, the remaining chunks are requested in the next ticks
+				if !n.downloadLimit.TryTake(file.ChunkLength(uint16(chunk))) {
+					missingChunks = nil
+					break
 				}
+
+				requestInfo.NumberOfTries++
+				chunksToRequest[nodeInfo] = append(chunksToRequest[nodeInfo], uint16(chunk)) // Queue chunk
 			}
 		}
 
@@ -245,16 +363,30 @@ func (n *Node) RequestChunks(chunkIndexes []uint16, nodeAddr *net.UDPAddr, file
 		return
 	}
 
-	packet := protocol.NewRequestChunksPacket(file.FileName, chunkIndexes)
+	packet := protocol.NewRequestChunksPacket(file.FileHash, chunkIndexes)
 	n.srv.EnqueueRequest(&packet, nodeAddr)
 
 	// Mark chunks as requested
+	cc := n.congestionController(nodeInfo.Address)
 	for _, chunkIndex := range chunkIndexes {
 		file.MarkChunkAsRequested(chunkIndex, nodeInfo)
+		file.PendingChunks.Put(chunkIndex, time.Now())
+		cc.Sent(ChunkRequest{file.FileHash, chunkIndex})
 	}
 }
 
 func (n *Node) Stop() {
+	n.stopping.Store(true)
+
+	n.forDownload.ForEach(func(_ [20]byte, file *ForDownloadFile) {
+		if file.UpdatedByTracker && !file.IsFileDownloaded() {
+			err := file.SaveState()
+			if err != nil {
+				logger.Error("Error saving download state of file %s: %v", file.FileName, err)
+			}
+		}
+	})
+
 	n.srv.Stop()
 	n.tck.Stop()
 	n.quitChannel <- struct{}{}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/ticker"
+	"net"
+	"time"
+)
+
+const (
+	PeerExchangeInterval = 10 * time.Second
+	MaxExchangedPeers    = 50 // Maximum number of nodes sent in a single peer exchange
+)
+
+func (n *Node) startPeerExchange() {
+	tck := ticker.NewTicker(PeerExchangeInterval, n.exchangePeers)
+	tck.Start()
+	n.pexTck = tck
+}
+
+// Sends, to every node of each download, the chunks this node has and the other nodes it knows with chunks of the file
+func (n *Node) exchangePeers() {
+	for _, file := range n.forDownload.Values() {
+		if !file.UpdatedByTracker || file.IsFileDownloaded() {
+			continue
+		}
+
+		nodes := file.Nodes.Values()
+		if len(nodes) == 0 {
+			continue
+		}
+
+		peers := make([]protocol.ExchangedPeer, 0, len(nodes))
+		for _, nodeInfo := range nodes {
+			peers = append(peers, protocol.ExchangedPeer{Addr: nodeInfo.Address, Bitfield: file.NodeBitfield(nodeInfo)})
+		}
+
+		bitfield := n.updateChunksPacket(file).Bitfield
+
+		for _, nodeInfo := range nodes {
+			addr, err := net.ResolveUDPAddr("udp4", nodeInfo.Address)
+			if err != nil {
+				continue
+			}
+
+			// The node is not sent to itself
+			exchanged := make([]protocol.ExchangedPeer, 0, MaxExchangedPeers)
+			for _, peer := range peers {
+				if len(exchanged) == MaxExchangedPeers {
+					break
+				}
+
+				if peer.Addr != nodeInfo.Address {
+					exchanged = append(exchanged, peer)
+				}
+			}
+
+			packet := protocol.NewPeerExchangePacket(file.FileHash, bitfield, exchanged)
+			n.srv.SendPacket(&packet, addr)
+		}
+	}
+}
+
+// Handler for when another node of a file being downloaded sends the chunks it has and the nodes it knows.
+// Only nodes already known to have the file are listened to, so a forged packet can not make this node
+// send requests to arbitrary addresses.
+func (n *Node) handlePeerExchangePacket(packet *protocol.PeerExchangePacket, addr *net.UDPAddr) {
+	file, ok := n.forDownload.Get(packet.FileHash)
+	if !ok || !file.UpdatedByTracker || !file.Nodes.Contains(addr.String()) {
+		return
+	}
+
+	file.UpsertNode(addr, packet.Bitfield)
+
+	peers := packet.Peers
+	if len(peers) > MaxExchangedPeers {
+		peers = peers[:MaxExchangedPeers]
+	}
+
+	added := 0
+	for _, peer := range peers {
+		peerAddr, err := net.ResolveUDPAddr("udp4", peer.Addr)
+		if err != nil || n.isOwnAddr(peerAddr) {
+			continue
+		}
+
+		// The bitfields of known nodes are kept, since they come from the nodes themselves or the tracker
+		if file.Nodes.Contains(peerAddr.String()) {
+			continue
+		}
+
+		file.UpsertNode(peerAddr, peer.Bitfield)
+		n.pingDHT(peerAddr)
+		added++
+	}
+
+	if added != 0 {
+		logger.Info("Learned about %d nodes with chunks of file %s from %s", added, file.FileName, addr)
+	}
+}
+
+// Reports whether the address is one this node can be reached at
+func (n *Node) isOwnAddr(addr *net.UDPAddr) bool {
+	if addr.Port != int(n.udpPort) {
+		return false
+	}
+
+	if addr.IP.IsLoopback() || addr.IP.IsUnspecified() {
+		return true
+	}
+
+	interfaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+
+	for _, interfaceAddr := range interfaceAddrs {
+		if ipNet, ok := interfaceAddr.(*net.IPNet); ok && ipNet.IP.Equal(addr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
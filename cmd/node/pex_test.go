@@ -0,0 +1,51 @@
+package main
+
+import (
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/structures"
+	"net"
+	"testing"
+)
+
+func TestPeerExchangeKeepsNodeState(t *testing.T) {
+	fileHash := [20]byte{1}
+	file := NewForDownloadFile("file", fileHash)
+	file.UpdatedByTracker = true
+	file.NumberOfChunks = 3
+	file.Nodes = structures.NewSynchronizedMap[string, *NodeInfo]()
+
+	n := &Node{forDownload: structures.NewSynchronizedMap[[20]byte, *ForDownloadFile]()}
+	n.forDownload.Put(fileHash, file)
+
+	addr, _ := net.ResolveUDPAddr("udp4", "127.0.0.1:8081")
+	file.UpsertNode(addr, protocol.EncodeBitField([]bool{true, false, true}))
+
+	nodeInfo, _ := file.Nodes.Get(addr.String())
+	nodeInfo.Timeouts = 2
+	requestInfo, _ := nodeInfo.Chunks.Get(0)
+	requestInfo.NumberOfTries = 1
+
+	packet := protocol.NewPeerExchangePacket(fileHash, protocol.EncodeBitField([]bool{true, true, false}), nil)
+	n.handlePeerExchangePacket(&packet, addr)
+
+	updated, ok := file.Nodes.Get(addr.String())
+	if !ok {
+		t.Fatalf("Expected node %s to still be a node of the file", addr)
+	}
+
+	if updated.Timeouts != 2 {
+		t.Errorf("Expected node to keep its 2 timeouts, got %d", updated.Timeouts)
+	}
+
+	if requestInfo, ok := updated.Chunks.Get(0); !ok || requestInfo.NumberOfTries != 1 {
+		t.Errorf("Expected chunk 0 to keep its request, got %v", requestInfo)
+	}
+
+	if !updated.Chunks.Contains(1) {
+		t.Errorf("Expected node to have chunk 1")
+	}
+
+	if updated.Chunks.Contains(2) {
+		t.Errorf("Expected node to no longer have chunk 2")
+	}
+}
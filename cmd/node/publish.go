@@ -0,0 +1,270 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	PublishWorkers   = 4  // Number of files hashed at the same time
+	PublishBatchSize = 64 // Number of files sent to the tracker in a single packet
+)
+
+// Result of publishing a file, as answered by the tracker
+const (
+	Published = iota
+	Duplicate
+	Failed
+)
+
+// PublishSummary keeps track of the files of a publish-all command, until the tracker answers all of them
+type PublishSummary struct {
+	sync.Mutex
+	directory string
+	sent      map[[20]byte]bool // File hashes sent to the tracker
+	pending   map[[20]byte]bool // File hashes not answered by the tracker yet
+	hashing   bool              // Whether there are still files being hashed
+
+	published uint
+	duplicate uint
+	failed    uint
+}
+
+func NewPublishSummary(directory string) *PublishSummary {
+	return &PublishSummary{
+		directory: directory,
+		sent:      make(map[[20]byte]bool),
+		pending:   make(map[[20]byte]bool),
+		hashing:   true,
+	}
+}
+
+// Marks a file as sent to the tracker. Returns false, counting it as a duplicate,
+// if a file with the same content was already sent, since the answers to both could be handled out of order.
+func (ps *PublishSummary) Send(fileHash [20]byte) bool {
+	ps.Lock()
+	defer ps.Unlock()
+
+	if ps.sent[fileHash] {
+		ps.duplicate++
+		return false
+	}
+
+	ps.sent[fileHash] = true
+	ps.pending[fileHash] = true
+	return true
+}
+
+// Records the answer of the tracker to a file, or a file that failed to be hashed (with an empty hash).
+// Returns false if the file is not part of the summary.
+func (ps *PublishSummary) Record(fileHash [20]byte, result int) bool {
+	ps.Lock()
+	defer ps.Unlock()
+
+	if result != Failed || fileHash != [20]byte{} {
+		if !ps.pending[fileHash] {
+			return false
+		}
+
+		delete(ps.pending, fileHash)
+	}
+
+	switch result {
+	case Published:
+		ps.published++
+	case Duplicate:
+		ps.duplicate++
+	default:
+		ps.failed++
+	}
+
+	return true
+}
+
+// Marks every file as hashed
+func (ps *PublishSummary) HashingDone() {
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.hashing = false
+}
+
+// Counts every file not answered by the tracker yet as failed
+func (ps *PublishSummary) Abort() {
+	ps.Lock()
+	defer ps.Unlock()
+
+	ps.failed += uint(len(ps.pending))
+	ps.pending = make(map[[20]byte]bool)
+}
+
+// Returns whether every file was hashed and answered by the tracker
+func (ps *PublishSummary) Done() bool {
+	ps.Lock()
+	defer ps.Unlock()
+
+	return !ps.hashing && len(ps.pending) == 0
+}
+
+func (ps *PublishSummary) String() string {
+	ps.Lock()
+	defer ps.Unlock()
+
+	return fmt.Sprintf("Published %s: %d files published, %d duplicates and %d errors", ps.directory, ps.published, ps.duplicate, ps.failed)
+}
+
+// publish-all <directory>
+func (n *Node) publishAll(args []string) error {
+	directory := args[0]
+
+	if !n.isAuthenticated() {
+		return fmt.Errorf("not authenticated with any tracker")
+	}
+
+	info, err := os.Stat(directory)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("path %s is not a directory", directory)
+	}
+
+	n.publishing.Lock()
+	defer n.publishing.Unlock()
+
+	if n.publishing.summary != nil {
+		return fmt.Errorf("already publishing directory %s", n.publishing.summary.directory)
+	}
+
+	summary := NewPublishSummary(directory)
+	n.publishing.summary = summary
+
+	// Hash the files in the background, so the CLI is not blocked
+	go n.publishTree(directory, summary)
+
+	return nil
+}
+
+// Hashes every file of the directory with a pool of workers, publishing them in batches
+func (n *Node) publishTree(directory string, summary *PublishSummary) {
+	paths := make(chan string)
+	packets := make(chan *protocol.PublishFilePacket)
+
+	var wg sync.WaitGroup
+	for i := 0; i < PublishWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				newFile, packet, err := n.hashFiles(path, nil)
+				if err != nil {
+					logger.Error("Error hashing file %s: %v", path, err)
+					summary.Record([20]byte{}, Failed)
+					continue
+				}
+
+				if !summary.Send(packet.FileHash) {
+					logger.Info("File %s has the same content as another file of %s", path, directory)
+					continue
+				}
+
+				n.pending.Put(newFile.FileHash, newFile)
+				packets <- packet
+			}
+		}()
+	}
+
+	go func() {
+		err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Error("Error reading %s: %v", path, err)
+				summary.Record([20]byte{}, Failed)
+				return nil
+			}
+
+			if d.Type().IsRegular() {
+				paths <- path
+			}
+
+			return nil
+		})
+		if err != nil {
+			logger.Error("Error walking directory %s: %v", directory, err)
+		}
+
+		close(paths)
+		wg.Wait()
+		close(packets)
+	}()
+
+	batch := make([]protocol.PublishFilePacket, 0, PublishBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		packet := protocol.NewPublishFilesPacket(batch)
+		n.sendToAuthenticatedTrackers(&packet)
+		logger.Info("Sent %d files of %s to the trackers", len(batch), directory)
+
+		batch = make([]protocol.PublishFilePacket, 0, PublishBatchSize)
+	}
+
+	for packet := range packets {
+		batch = append(batch, *packet)
+		if len(batch) == PublishBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	summary.HashingDone()
+	n.finishPublish(summary)
+}
+
+// Records the answer of the tracker to a file, if it is part of the directory being published
+func (n *Node) recordPublishResult(fileHash [20]byte, result int) {
+	n.publishing.Lock()
+	summary := n.publishing.summary
+	n.publishing.Unlock()
+
+	if summary != nil && summary.Record(fileHash, result) {
+		n.finishPublish(summary)
+	}
+}
+
+// Reports the summary of the directory being published, once every file was answered by the tracker
+func (n *Node) finishPublish(summary *PublishSummary) {
+	if !summary.Done() {
+		return
+	}
+
+	n.publishing.Lock()
+	defer n.publishing.Unlock()
+
+	if n.publishing.summary == summary {
+		n.publishing.summary = nil
+		logger.Info("%s", summary)
+	}
+}
+
+// Gives up on the directory being published, since no tracker is left to answer its files
+func (n *Node) abortPublish() {
+	n.publishing.Lock()
+	defer n.publishing.Unlock()
+
+	summary := n.publishing.summary
+	if summary == nil {
+		return
+	}
+
+	summary.Abort()
+	n.publishing.summary = nil
+	logger.Warn("Lost connection to every tracker while publishing. %s", summary)
+}
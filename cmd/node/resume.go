@@ -0,0 +1,258 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/multifile"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/utils"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	StateFileExtension = ".ptstate"
+	SaveStateInterval  = 5 * time.Second
+)
+
+// DownloadState is stored in a sidecar file next to a file being downloaded,
+// so that the download can be resumed if the node is restarted
+type DownloadState struct {
+	FileName      string
+	FileSize      uint64
+	FileHash      [20]byte
+	HashAlgorithm uint8
+	HashMode      uint8
+	ChunkHashes   [][]uint8
+	MerkleNodes   [][]uint8         // Nodes of the merkle tree, only in merkle hash mode
+	MerkleKnown   protocol.Bitfield // Which nodes of the merkle tree were already verified
+	Files         []protocol.ManifestEntry
+	Bitfield      protocol.Bitfield
+}
+
+func StatePath(filePath string) string {
+	return filePath + StateFileExtension
+}
+
+func SaveDownloadState(path string, state *DownloadState) error {
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	err = protocol.SerializeStruct(writer, state)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func LoadDownloadState(path string) (*DownloadState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var state DownloadState
+	err = protocol.DeserializeToStruct(bufio.NewReader(file), &state)
+	if err != nil {
+		return nil, fmt.Errorf("error reading download state: %w", err)
+	}
+
+	return &state, nil
+}
+
+// Resumes every download which has a state file in the given directory
+func (n *Node) resumeDownloads(directory string) {
+	statePaths, err := filepath.Glob(filepath.Join(directory, "*"+StateFileExtension))
+	if err != nil {
+		logger.Error("Error looking for downloads to resume: %v", err)
+		return
+	}
+
+	for _, statePath := range statePaths {
+		err := n.resumeDownload(statePath)
+		if err != nil {
+			logger.Error("Error resuming download from %s: %v", statePath, err)
+		}
+	}
+}
+
+func (n *Node) resumeDownload(statePath string) error {
+	state, err := LoadDownloadState(statePath)
+	if err != nil {
+		return err
+	}
+
+	if n.forDownload.Contains(state.FileHash) {
+		return nil
+	}
+
+	directory := filepath.Dir(statePath)
+	if filepath.Base(strings.TrimSuffix(statePath, StateFileExtension)) != DownloadName(state.FileName, state.FileHash) {
+		return fmt.Errorf("state file does not belong to file %s", state.FileName)
+	}
+
+	hasher, err := utils.HasherFromAlgorithm(state.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	numberOfChunks := int(utils.NumberOfChunks(state.FileSize))
+
+	var tree *utils.MerkleTree
+	if state.HashMode == protocol.HashModeMerkle {
+		tree, err = utils.RestoreMerkleTree(hasher, numberOfChunks, state.MerkleNodes, protocol.DecodeBitField(state.MerkleKnown))
+		if err != nil {
+			return err
+		}
+	} else if len(state.ChunkHashes) != numberOfChunks {
+		return fmt.Errorf("state has %d chunk hashes instead of %d", len(state.ChunkHashes), numberOfChunks)
+	}
+
+	file := NewForDownloadFile(state.FileName, state.FileHash)
+	err = file.SetData(hasher, state.ChunkHashes, tree, state.FileSize, uint16(numberOfChunks), state.Files, directory)
+	if err != nil {
+		return err
+	}
+
+	verified, err := file.VerifyWrittenChunks(state.Bitfield)
+	if err != nil {
+		file.FileWriter.Stop()
+		return err
+	}
+
+	err = file.SaveState()
+	if err != nil {
+		logger.Error("Error saving download state of file %s: %v", state.FileName, err)
+	}
+
+	file.DownloadStarted = time.Now()
+	file.UpdatedByTracker = true
+	file.LastServerChunksUpdate = time.Time{} // Announce the chunks we already have as soon as possible
+	file.LastNodesUpdate = time.Time{}        // and ask for the nodes which have the rest
+	file.OnChunkDownloaded = func(chunkIndex uint16) {
+		n.announceChunk(file, chunkIndex)
+	}
+	n.forDownload.Put(state.FileHash, file)
+
+	logger.Info("Resumed download of file %s (%d/%d chunks already downloaded)", state.FileName, verified, file.NumberOfChunks)
+
+	return nil
+}
+
+// Returns the current state of the download
+func (f *ForDownloadFile) State() *DownloadState {
+	downloaded := make([]bool, 0, f.NumberOfChunks)
+	f.Chunks.ForEach(func(chunk ChunkInfo) {
+		downloaded = append(downloaded, chunk.Downloaded)
+	})
+
+	state := &DownloadState{
+		FileName:      f.FileName,
+		FileSize:      f.FileSize,
+		FileHash:      f.FileHash,
+		HashAlgorithm: f.Hasher.Algorithm(),
+		HashMode:      protocol.HashModeFlat,
+		ChunkHashes:   f.ChunkHashes,
+		Files:         f.Files,
+		Bitfield:      protocol.EncodeBitField(downloaded),
+	}
+
+	if f.Tree != nil {
+		nodes, known := f.Tree.Nodes()
+		state.HashMode = protocol.HashModeMerkle
+		state.ChunkHashes = nil
+		state.MerkleNodes = nodes
+		state.MerkleKnown = protocol.EncodeBitField(known)
+	}
+
+	return state
+}
+
+func (f *ForDownloadFile) SaveState() error {
+	f.LastStateSave = time.Now()
+	return SaveDownloadState(StatePath(f.FilePath), f.State())
+}
+
+func (f *ForDownloadFile) RemoveState() error {
+	err := os.Remove(StatePath(f.FilePath))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// Checks the hash of every chunk the bitfield claims to be written to disk,
+// marking the valid ones as downloaded. Returns the number of valid chunks.
+func (f *ForDownloadFile) VerifyWrittenChunks(bitfield protocol.Bitfield) (int, error) {
+	file, err := multifile.Open(f.FilePath, f.Files)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	chunkSize := utils.ChunkSize(f.FileSize)
+	chunkContent := make([]byte, chunkSize)
+	decoded := protocol.DecodeBitField(bitfield)
+
+	verified := 0
+	for index := 0; index < int(f.NumberOfChunks) && index < len(decoded); index++ {
+		if !decoded[index] {
+			continue
+		}
+
+		read, err := file.ReadAt(chunkContent, int64(index)*int64(chunkSize))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return verified, err
+		}
+
+		if bytes.Equal(utils.HashChunk(chunkContent[:read], f.Hasher), f.GetChunkHash(uint16(index))) {
+			f.MarkChunkAsDownloaded(uint16(index))
+			verified++
+		}
+	}
+
+	return verified, nil
+}
+
+// Checks that the content written to disk has the hash of the file
+func (f *ForDownloadFile) VerifyFileHash() error {
+	file, err := multifile.Open(f.FilePath, f.Files)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fileHash, err := utils.HashFile(io.NewSectionReader(file, 0, int64(file.Size())), f.Hasher)
+	if err != nil {
+		return err
+	}
+
+	if fileHash != f.FileHash {
+		return fmt.Errorf("content has hash %x instead of %x", fileHash, f.FileHash)
+	}
+
+	return nil
+}
@@ -50,6 +50,21 @@ func (stats *NodeStatistics) getAverageDownloadSpeed(addr string) float64 {
 	return totalSpeed / float64(speedCount)
 }
 
+// Returns the bytes per second downloaded from the node during the last period
+func (stats *NodeStatistics) getRecentDownloadRate(addr string, period time.Duration) float64 {
+	stats.Lock()
+	defer stats.Unlock()
+
+	var downloaded uint64
+	for _, chunk := range stats.nodeMap[addr] {
+		if time.Since(chunk.TimestampReceived) < period {
+			downloaded += uint64(chunk.ChunkSize)
+		}
+	}
+
+	return float64(downloaded) / period.Seconds()
+}
+
 func (stats *NodeStatistics) addUploadedBytes(bytes uint64) {
 	stats.Lock()
 	defer stats.Unlock()
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/transport"
+	"PessiTorrent/internal/utils"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// TrackerConnection is the connection of the node to one of the trackers it announces its files to
+type TrackerConnection struct {
+	addr          string
+	conn          atomic.Pointer[transport.TCPConnection] // Current connection, replaced when reconnecting
+	connected     atomic.Bool                             // Whether the node is connected to the tracker or not
+	authenticated atomic.Bool                             // Whether the tracker allows the node to publish and remove files
+
+	lastHeartbeatAck atomic.Int64 // Unix time, in nanoseconds, of the last heartbeat ack received from the tracker
+	reconnecting     atomic.Bool  // Whether the node is trying to reconnect to the tracker
+}
+
+func NewTrackerConnection(addr string) *TrackerConnection {
+	return &TrackerConnection{
+		addr: addr,
+	}
+}
+
+// Queues the packet to be sent to the tracker. Packets are discarded if the node never connected to it.
+func (tc *TrackerConnection) send(packet protocol.Packet) {
+	if conn := tc.conn.Load(); conn != nil {
+		conn.EnqueuePacket(packet)
+	}
+}
+
+// Connects to the tracker and announces the node to it
+func (n *Node) dialTracker(tracker *TrackerConnection) error {
+	netConn, err := net.Dial("tcp4", tracker.addr)
+	if err != nil {
+		return err
+	}
+
+	tracker.lastHeartbeatAck.Store(time.Now().UnixNano())
+	var conn transport.TCPConnection
+	conn = transport.NewTCPConnection(netConn, n.HandlePackets, func() {
+		n.onTrackerDisconnected(tracker, &conn)
+	})
+	tracker.conn.Store(&conn)
+	tracker.connected.Store(true)
+	go conn.Start()
+
+	logger.Info("Connected to tracker on %s", tracker.addr)
+
+	// Notify tracker of node's existence
+	ipAddr := utils.TCPAddrToBytes(conn.LocalAddr())
+	domain, err := n.dns.ResolveDomain(net.IP(ipAddr[:]).String())
+
+	// Trackers are dialed in parallel, so the name is read and written under its lock
+	n.name.Lock()
+	if err != nil {
+		// A reconnecting node announces itself with the name it had before
+		if n.name.value == "" {
+			n.name.Unlock()
+			logger.Error("Error resolving domain: %v", err)
+			return nil
+		}
+
+		logger.Warn("Error resolving domain: %v. Using previous name %s", err, n.name.value)
+		domain = n.name.value
+	}
+	n.name.value = domain
+	n.name.Unlock()
+
+	packet := protocol.NewInitPacket(domain, n.udpPort)
+	conn.EnqueuePacket(&packet)
+
+	return nil
+}
+
+// Returns the name the node announced itself with
+func (n *Node) getName() string {
+	n.name.Lock()
+	defer n.name.Unlock()
+
+	return n.name.value
+}
+
+// Called when the connection to a tracker is closed, either by the tracker or by the node
+func (n *Node) onTrackerDisconnected(tracker *TrackerConnection, conn *transport.TCPConnection) {
+	// The connection may have been replaced already
+	if tracker.conn.Load() != conn {
+		return
+	}
+
+	tracker.connected.Store(false)
+	tracker.authenticated.Store(false)
+
+	if !n.isAuthenticated() {
+		n.abortPublish()
+	}
+
+	if n.stopping.Load() {
+		return
+	}
+
+	logger.Warn("Lost connection to tracker on %s", tracker.addr)
+	go n.reconnect(tracker)
+}
+
+// Tries to connect to the tracker again, waiting exponentially longer between each try.
+// Meanwhile, downloads keep going with the peers known from the other trackers.
+func (n *Node) reconnect(tracker *TrackerConnection) {
+	if !tracker.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	defer tracker.reconnecting.Store(false)
+
+	backoff := ReconnectInitialBackoff
+	for !n.stopping.Load() {
+		logger.Info("Reconnecting to tracker on %s in %v", tracker.addr, backoff)
+		time.Sleep(backoff)
+
+		err := n.dialTracker(tracker)
+		if err == nil {
+			return
+		}
+
+		logger.Warn("Failed to reconnect to tracker on %s: %v", tracker.addr, err)
+		backoff = min(2*backoff, ReconnectMaxBackoff)
+	}
+}
+
+// Returns the tracker the connection belongs to
+func (n *Node) trackerOf(conn *transport.TCPConnection) *TrackerConnection {
+	connected := n.trackers.Filter(func(tracker *TrackerConnection) bool {
+		return tracker.conn.Load() == conn
+	})
+
+	if len(connected) == 0 {
+		return nil
+	}
+
+	return connected[0]
+}
+
+// Returns whether the node is connected to any tracker
+func (n *Node) isConnected() bool {
+	return len(n.trackers.Filter(func(tracker *TrackerConnection) bool { return tracker.connected.Load() })) != 0
+}
+
+// Returns whether any tracker allows the node to publish and remove files
+func (n *Node) isAuthenticated() bool {
+	return len(n.trackers.Filter(func(tracker *TrackerConnection) bool { return tracker.authenticated.Load() })) != 0
+}
+
+// Sends the packet to every connected tracker, so their answers are merged
+func (n *Node) sendToTrackers(packet protocol.Packet) {
+	n.trackers.ForEach(func(tracker *TrackerConnection) {
+		if tracker.connected.Load() {
+			tracker.send(packet)
+		}
+	})
+}
+
+// Sends the packet to every tracker which allows the node to publish and remove files.
+// The others are announced every published file once they authenticate the node.
+func (n *Node) sendToAuthenticatedTrackers(packet protocol.Packet) {
+	n.trackers.ForEach(func(tracker *TrackerConnection) {
+		if tracker.authenticated.Load() {
+			tracker.send(packet)
+		}
+	})
+}
+
+// Sends the packet to the first connected tracker, for queries which only need a single answer
+func (n *Node) sendToTracker(packet protocol.Packet) error {
+	connected := n.trackers.Filter(func(tracker *TrackerConnection) bool { return tracker.connected.Load() })
+	if len(connected) == 0 {
+		return fmt.Errorf("not connected to any tracker")
+	}
+
+	connected[0].send(packet)
+	return nil
+}
+
+// Shows the trackers the node is still alive, so they keep advertising the node's chunks
+func (n *Node) sendHeartbeats() {
+	n.trackers.ForEach(func(tracker *TrackerConnection) {
+		conn := tracker.conn.Load()
+		if !tracker.connected.Load() || conn == nil {
+			return
+		}
+
+		// The connection may be half-open, so it is closed to reconnect to the tracker
+		silence := time.Since(time.Unix(0, tracker.lastHeartbeatAck.Load()))
+		if silence > TrackerTimeout {
+			logger.Warn("Tracker on %s has not answered heartbeats for %v. Closing connection", tracker.addr, silence.Round(time.Second))
+			conn.Stop()
+			return
+		}
+
+		packet := protocol.NewHeartbeatPacket()
+		conn.EnqueuePacket(&packet)
+	})
+}
+
+// Announces every file the node seeds or is downloading to the tracker, since it may not know about them
+// (e.g. if it restarted without storage, evicted the node or was added after the files were published)
+func (n *Node) reannounce(tracker *TrackerConnection) {
+	packets := make([]protocol.PublishFilePacket, 0)
+	n.published.ForEach(func(_ [20]byte, file *File) {
+		if file.PublishPacket != nil {
+			packets = append(packets, *file.PublishPacket)
+		}
+	})
+
+	for start := 0; start < len(packets); start += PublishBatchSize {
+		end := min(start+PublishBatchSize, len(packets))
+
+		packet := protocol.NewPublishFilesPacket(packets[start:end])
+		tracker.send(&packet)
+	}
+
+	updated := 0
+	n.forDownload.ForEach(func(_ [20]byte, file *ForDownloadFile) {
+		if file.UpdatedByTracker && !file.IsFileDownloaded() {
+			packet := n.updateChunksPacket(file)
+			tracker.send(&packet)
+
+			subscribePacket := protocol.NewSubscribeFilePacket(file.FileHash)
+			tracker.send(&subscribePacket)
+
+			// The tracker may know nodes which joined the swarm while the node was not connected to it
+			updatePacket := protocol.NewUpdateFilePacket(file.FileHash)
+			tracker.send(&updatePacket)
+			updated++
+		}
+	})
+
+	if len(packets) != 0 || updated != 0 {
+		logger.Info("Announced %d published files and the chunks of %d downloads to the tracker on %s", len(packets), updated, tracker.addr)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"net"
+)
+
+const (
+	UploadWorkers   = UploadSlots // Number of requests whose chunks are sent at the same time
+	UploadQueueSize = 64          // Number of requests waiting to be sent, after which peers are choked
+)
+
+// Request of chunks waiting for an upload worker
+type uploadRequest struct {
+	file   *File
+	packet *protocol.RequestChunksPacket
+	addr   *net.UDPAddr
+}
+
+// Starts the workers sending the requested chunks. Uploads may wait for the upload rate limit,
+// so the requests are queued instead of keeping a goroutine and an open file for each one.
+func (n *Node) startUploads() {
+	for i := 0; i < UploadWorkers; i++ {
+		go func() {
+			for request := range n.uploadQueue {
+				n.sendFileChunks(request.file, request.packet, request.addr)
+			}
+		}()
+	}
+}
+
+// Queues the requested chunks to be sent. If too many requests are already waiting,
+// the peer is choked, so it requests them again later or from other nodes.
+func (n *Node) enqueueUpload(file *File, packet *protocol.RequestChunksPacket, addr *net.UDPAddr) {
+	select {
+	case n.uploadQueue <- uploadRequest{file, packet, addr}:
+	default:
+		logger.Warn("Too many chunk requests waiting to be sent. Choking node %s", addr)
+
+		chokePacket := protocol.NewChokePacket()
+		n.srv.SendPacket(&chokePacket, addr)
+	}
+}
@@ -4,22 +4,67 @@ import (
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/structures"
 	"PessiTorrent/internal/transport"
+	"PessiTorrent/internal/utils"
+	"bytes"
+	"sync/atomic"
+	"time"
 )
 
 type TrackedFile struct {
-	FileName    string
-	FileSize    uint64
-	FileHash    [20]byte
-	ChunkHashes [][20]byte
+	FileName      string
+	FileSize      uint64
+	FileHash      [20]byte
+	HashAlgorithm uint8
+	HashMode      uint8
+	ChunkHashes   [][]byte                 // Only in flat hash mode
+	MerkleRoot    []byte                   // Only in merkle hash mode
+	Files         []protocol.ManifestEntry // Files of the directory, if the file is one
+
+	// Name of the node which published the file, the only one (besides admins) allowed to remove it
+	Owner string
 }
 
-func NewTrackedFile(fileName string, fileSize uint64, fileHash [20]byte, chunkHashes [][20]byte) TrackedFile {
+func NewTrackedFile(fileName string, fileSize uint64, fileHash [20]byte, hashAlgorithm uint8, hashMode uint8, chunkHashes [][]byte, merkleRoot []byte, files []protocol.ManifestEntry, owner string) TrackedFile {
 	return TrackedFile{
-		FileName:    fileName,
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		FileHash:      fileHash,
+		HashAlgorithm: hashAlgorithm,
+		HashMode:      hashMode,
+		ChunkHashes:   chunkHashes,
+		MerkleRoot:    merkleRoot,
+		Files:         files,
+		Owner:         owner,
+	}
+}
+
+// HasSameContent reports whether the given file information describes the same content as the tracked file
+func (tf *TrackedFile) HasSameContent(fileSize uint64, fileHash [20]byte, hashAlgorithm uint8, hashMode uint8, chunkHashes [][]byte, merkleRoot []byte, files []protocol.ManifestEntry) bool {
+	if tf.FileSize != fileSize || tf.FileHash != fileHash || tf.HashAlgorithm != hashAlgorithm || tf.HashMode != hashMode {
+		return false
 	}
+
+	if !bytes.Equal(tf.MerkleRoot, merkleRoot) || len(tf.ChunkHashes) != len(chunkHashes) || len(tf.Files) != len(files) {
+		return false
+	}
+
+	for i, chunkHash := range tf.ChunkHashes {
+		if !bytes.Equal(chunkHash, chunkHashes[i]) {
+			return false
+		}
+	}
+
+	for i, file := range tf.Files {
+		if file != files[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (tf *TrackedFile) NumberOfChunks() int {
+	return int(utils.NumberOfChunks(tf.FileSize))
 }
 
 type NodeInfo struct {
@@ -27,7 +72,14 @@ type NodeInfo struct {
 	conn    transport.TCPConnection
 	udpPort uint16
 
-	files structures.SynchronizedMap[string, protocol.Bitfield]
+	// Whether the node has proven to know its pre-shared key, and the nonce it has to answer to
+	authenticated atomic.Bool
+	challenge     atomic.Pointer[[32]byte]
+
+	files         structures.SynchronizedMap[[20]byte, protocol.Bitfield] // File hash -> Bitfield
+	subscriptions structures.SynchronizedMap[[20]byte, bool]              // Files whose swarm deltas are pushed to the node
+
+	lastSeen atomic.Int64 // Unix time, in nanoseconds, of the last packet received from the node
 }
 
 func NewNodeInfo(conn transport.TCPConnection, udpPort uint16, name string) NodeInfo {
@@ -35,6 +87,32 @@ func NewNodeInfo(conn transport.TCPConnection, udpPort uint16, name string) Node
 		name:    name,
 		conn:    conn,
 		udpPort: udpPort,
-		files:   structures.NewSynchronizedMap[string, protocol.Bitfield](),
+		files:   structures.NewSynchronizedMap[[20]byte, protocol.Bitfield](),
+
+		subscriptions: structures.NewSynchronizedMap[[20]byte, bool](),
+	}
+}
+
+// Seen records that a packet was just received from the node
+func (ni *NodeInfo) Seen() {
+	ni.lastSeen.Store(time.Now().UnixNano())
+}
+
+// SilentFor returns the time since the last packet was received from the node
+func (ni *NodeInfo) SilentFor() time.Duration {
+	return time.Since(time.Unix(0, ni.lastSeen.Load()))
+}
+
+// StaleNode holds the bitfields of a node which is not connected to the tracker.
+// They are not advertised to other nodes until the node reconnects.
+type StaleNode struct {
+	files map[[20]byte]protocol.Bitfield
+	since time.Time
+}
+
+func NewStaleNode(files map[[20]byte]protocol.Bitfield) StaleNode {
+	return StaleNode{
+		files: files,
+		since: time.Now(),
 	}
 }
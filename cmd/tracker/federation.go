@@ -0,0 +1,241 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/transport"
+	"bytes"
+	"crypto/sha1"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	FederationSyncInterval  = 5 * time.Second
+	FederationRetryInterval = 5 * time.Second
+)
+
+// FederatedTracker is a tracker this tracker is subscribed to, with the last state it sent.
+// Only the files published in it and the nodes connected to it are sent, so trackers must subscribe to each other.
+type FederatedTracker struct {
+	sync.Mutex
+	files map[[20]byte]*TrackedFile // File hash -> File
+	nodes []protocol.FederatedNode
+}
+
+func NewFederatedTracker() *FederatedTracker {
+	return &FederatedTracker{
+		files: make(map[[20]byte]*TrackedFile),
+	}
+}
+
+// Subscribes to the state of every tracker this tracker federates with
+func (t *Tracker) startFederation() {
+	for _, addr := range t.federationAddrs {
+		go t.subscribe(addr)
+	}
+}
+
+// Connects to the tracker and subscribes to its state, connecting again whenever the connection is lost
+func (t *Tracker) subscribe(addr string) {
+	for {
+		cn, err := net.Dial("tcp4", addr)
+		if err != nil {
+			logger.Warn("Failed to connect to federated tracker on %s: %v", addr, err)
+			time.Sleep(FederationRetryInterval)
+			continue
+		}
+
+		closed := make(chan struct{})
+		conn := transport.NewTCPConnection(cn, t.HandlePackets, func() {
+			close(closed)
+		})
+
+		// The state is only accepted from connections to the federated trackers
+		key := cn.RemoteAddr().String()
+		t.federation.Put(key, NewFederatedTracker())
+		go conn.Start()
+
+		logger.Info("Federated with tracker on %s", addr)
+
+		packet := protocol.NewFederationSubscribePacket()
+		conn.EnqueuePacket(&packet)
+
+		<-closed
+		t.federation.Delete(key)
+		logger.Warn("Lost connection to federated tracker on %s", addr)
+
+		time.Sleep(FederationRetryInterval)
+	}
+}
+
+// Returns the files published in the tracker and the bitfields of the nodes connected to it,
+// sorted so the same state is always serialized the same way
+func (t *Tracker) federationState() protocol.FederationStatePacket {
+	files := make([]protocol.FederatedFile, 0, t.files.Len())
+	t.files.ForEach(func(_ [20]byte, file *TrackedFile) {
+		files = append(files, protocol.FederatedFile{
+			FileName:      file.FileName,
+			FileSize:      file.FileSize,
+			FileHash:      file.FileHash,
+			HashAlgorithm: file.HashAlgorithm,
+			HashMode:      file.HashMode,
+			ChunkHashes:   file.ChunkHashes,
+			MerkleRoot:    file.MerkleRoot,
+			Files:         file.Files,
+			Owner:         file.Owner,
+		})
+	})
+
+	nodes := make([]protocol.FederatedNode, 0, t.nodes.Len())
+	t.nodes.ForEach(func(_ string, nodeInfo *NodeInfo) {
+		node := protocol.FederatedNode{
+			Name:  nodeInfo.name,
+			Port:  nodeInfo.udpPort,
+			Files: make([]protocol.FederatedBitfield, 0),
+		}
+
+		nodeInfo.files.ForEach(func(fileHash [20]byte, bitfield protocol.Bitfield) {
+			node.Files = append(node.Files, protocol.FederatedBitfield{FileHash: fileHash, Bitfield: bitfield})
+		})
+		sort.Slice(node.Files, func(i, j int) bool {
+			return bytes.Compare(node.Files[i].FileHash[:], node.Files[j].FileHash[:]) < 0
+		})
+
+		nodes = append(nodes, node)
+	})
+
+	sort.Slice(files, func(i, j int) bool {
+		return bytes.Compare(files[i].FileHash[:], files[j].FileHash[:]) < 0
+	})
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Name != nodes[j].Name {
+			return nodes[i].Name < nodes[j].Name
+		}
+		return nodes[i].Port < nodes[j].Port
+	})
+
+	return protocol.NewFederationStatePacket(files, nodes)
+}
+
+// Sends the state of the tracker to every tracker subscribed to it, if it changed since the last time.
+// New subscribers are sent the whole state when they subscribe.
+func (t *Tracker) pushFederationState() {
+	if t.subscribers.Len() == 0 {
+		return
+	}
+
+	packet := t.federationState()
+
+	buffer := new(bytes.Buffer)
+	err := protocol.SerializePacket(buffer, &packet)
+	if err != nil {
+		logger.Error("Error serializing federation state: %v", err)
+		return
+	}
+
+	digest := sha1.Sum(buffer.Bytes())
+	if digest == t.federationDigest {
+		return
+	}
+	t.federationDigest = digest
+
+	for _, conn := range t.subscribers.Values() {
+		conn.EnqueuePacket(&packet)
+	}
+}
+
+// Returns whether the address belongs to one of the trackers this tracker federates with.
+// Their connections come from any port, so only the IP is compared.
+func (t *Tracker) isFederationPeer(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, federationAddr := range t.federationAddrs {
+		peerAddr, err := net.ResolveTCPAddr("tcp4", federationAddr)
+		if err == nil && peerAddr.IP.Equal(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *Tracker) handleFederationSubscribePacket(_ *protocol.FederationSubscribePacket, conn *transport.TCPConnection) {
+	// The state has every file and node of the tracker, so it is only sent to the trackers it federates with
+	if !t.isFederationPeer(conn.RemoteAddr()) {
+		logger.Warn("Federation subscription rejected from %s, which is not a federated tracker", conn.RemoteAddr())
+		return
+	}
+
+	logger.Info("Tracker %s subscribed to the state of this tracker", conn.RemoteAddr())
+
+	t.subscribers.Put(conn.RemoteAddr().String(), conn)
+
+	packet := t.federationState()
+	conn.EnqueuePacket(&packet)
+}
+
+func (t *Tracker) handleFederationStatePacket(packet *protocol.FederationStatePacket, conn *transport.TCPConnection) {
+	federated, ok := t.federation.Get(conn.RemoteAddr().String())
+	if !ok {
+		logger.Warn("Federation state received from %s, which is not a federated tracker", conn.RemoteAddr())
+		return
+	}
+
+	files := make(map[[20]byte]*TrackedFile, len(packet.Files))
+	for _, f := range packet.Files {
+		file := NewTrackedFile(f.FileName, f.FileSize, f.FileHash, f.HashAlgorithm, f.HashMode, f.ChunkHashes, f.MerkleRoot, f.Files, f.Owner)
+		files[f.FileHash] = &file
+	}
+
+	federated.Lock()
+	defer federated.Unlock()
+
+	federated.files = files
+	federated.nodes = packet.Nodes
+}
+
+// Returns the files known from the federated trackers which are not published in this tracker
+func (t *Tracker) federatedFiles() []*TrackedFile {
+	seen := make(map[[20]byte]bool)
+	files := make([]*TrackedFile, 0)
+
+	t.federation.ForEach(func(_ string, federated *FederatedTracker) {
+		federated.Lock()
+		defer federated.Unlock()
+
+		for fileHash, file := range federated.files {
+			if !seen[fileHash] && !t.files.Contains(fileHash) {
+				seen[fileHash] = true
+				files = append(files, file)
+			}
+		}
+	})
+
+	return files
+}
+
+// Returns the file with the given hash, published in this tracker or in a federated one
+func (t *Tracker) getFile(fileHash [20]byte) (*TrackedFile, bool) {
+	if file, ok := t.files.Get(fileHash); ok {
+		return file, true
+	}
+
+	for _, file := range t.federatedFiles() {
+		if file.FileHash == fileHash {
+			return file, true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns every file published in this tracker or in a federated one
+func (t *Tracker) allFiles() []*TrackedFile {
+	return append(t.files.Values(), t.federatedFiles()...)
+}
@@ -2,16 +2,28 @@ package main
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/multifile"
 	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/transport"
+	"PessiTorrent/internal/utils"
+	"bytes"
 )
 
 func (t *Tracker) HandlePackets(packet protocol.Packet, conn *transport.TCPConnection) {
+	// Any packet shows the node is still alive, not only heartbeats
+	if nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String()); ok {
+		nodeInfo.Seen()
+	}
+
 	switch packet := packet.(type) {
 	case *protocol.InitPacket:
 		t.handleInitPacket(packet, conn)
+	case *protocol.ChallengeResponsePacket:
+		t.handleChallengeResponsePacket(packet, conn)
 	case *protocol.PublishFilePacket:
 		t.handlePublishFilePacket(packet, conn)
+	case *protocol.PublishFilesPacket:
+		t.handlePublishFilesPacket(packet, conn)
 	case *protocol.RequestFilePacket:
 		t.handleRequestFilePacket(packet, conn)
 	case *protocol.UpdateFilePacket:
@@ -20,6 +32,22 @@ func (t *Tracker) HandlePackets(packet protocol.Packet, conn *transport.TCPConne
 		t.handleRemoveFilePacket(packet, conn)
 	case *protocol.UpdateChunksPacket:
 		t.handlePublishChunkPacket(packet, conn)
+	case *protocol.SearchPacket:
+		t.handleSearchPacket(packet, conn)
+	case *protocol.ListFilesPacket:
+		t.handleListFilesPacket(packet, conn)
+	case *protocol.RequestPeersPacket:
+		t.handleRequestPeersPacket(packet, conn)
+	case *protocol.HeartbeatPacket:
+		t.handleHeartbeatPacket(packet, conn)
+	case *protocol.SubscribeFilePacket:
+		t.handleSubscribeFilePacket(packet, conn)
+	case *protocol.UnsubscribeFilePacket:
+		t.handleUnsubscribeFilePacket(packet, conn)
+	case *protocol.FederationSubscribePacket:
+		t.handleFederationSubscribePacket(packet, conn)
+	case *protocol.FederationStatePacket:
+		t.handleFederationStatePacket(packet, conn)
 	default:
 		logger.Error("Unknown packet type received from %s", conn.RemoteAddr())
 	}
@@ -29,83 +57,233 @@ func (t *Tracker) handleInitPacket(packet *protocol.InitPacket, conn *transport.
 	logger.Info("Init packet received from %s", conn.RemoteAddr())
 
 	newNode := NewNodeInfo(*conn, packet.UDPPort, packet.Name)
+	newNode.Seen()
+
+	// Authentication is disabled when no pre-shared keys are configured
+	if len(t.keys) == 0 {
+		t.nodes.Put(conn.RemoteAddr().String(), &newNode)
+		t.authenticateNode(&newNode, conn)
+
+		logger.Info("Registered node with data: %v, %v", packet.Name, packet.UDPPort)
+		return
+	}
+
+	if _, ok := t.keys[packet.Name]; !ok {
+		logger.Warn("Node %s from %s has no pre-shared key. Closing connection", packet.Name, conn.RemoteAddr())
+		conn.Stop()
+		return
+	}
+
+	nonce, err := utils.NewNonce()
+	if err != nil {
+		logger.Error("Error generating challenge for node %s: %v", packet.Name, err)
+		conn.Stop()
+		return
+	}
+	newNode.challenge.Store(&nonce)
 	t.nodes.Put(conn.RemoteAddr().String(), &newNode)
 
 	logger.Info("Registered node with data: %v, %v", packet.Name, packet.UDPPort)
+
+	challengePacket := protocol.NewChallengePacket(nonce)
+	conn.EnqueuePacket(&challengePacket)
+}
+
+func (t *Tracker) handleChallengeResponsePacket(packet *protocol.ChallengeResponsePacket, conn *transport.TCPConnection) {
+	logger.Info("Challenge response packet received from %s", conn.RemoteAddr())
+
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if !ok || nodeInfo.authenticated.Load() {
+		return
+	}
+
+	challenge := nodeInfo.challenge.Load()
+	key := t.keys[nodeInfo.name]
+	if challenge == nil || !utils.ValidChallengeMAC(key, *challenge, nodeInfo.name, packet.MAC) {
+		logger.Warn("Node %s from %s failed to authenticate. Closing connection", nodeInfo.name, conn.RemoteAddr())
+		conn.Stop()
+		return
+	}
+
+	t.authenticateNode(nodeInfo, conn)
+}
+
+// Marks the node as authenticated, restoring the bitfields it had before disconnecting
+// (or before the tracker restarted)
+func (t *Tracker) authenticateNode(nodeInfo *NodeInfo, conn *transport.TCPConnection) {
+	// Concurrent responses to the same challenge only authenticate the node once
+	if !nodeInfo.authenticated.CompareAndSwap(false, true) {
+		return
+	}
+
+	if staleNode, ok := t.stale.Get(nodeInfo.name); ok {
+		for fileHash, bitfield := range staleNode.files {
+			if t.files.Contains(fileHash) {
+				nodeInfo.files.Put(fileHash, bitfield)
+			}
+		}
+		t.stale.Delete(nodeInfo.name)
+
+		logger.Info("Restored bitfields of %d files for node %s", nodeInfo.files.Len(), nodeInfo.name)
+	}
+
+	logger.Info("Node %s authenticated", nodeInfo.name)
+
+	authPacket := protocol.NewAuthenticatedPacket()
+	conn.EnqueuePacket(&authPacket)
+}
+
+// Closes the connection of nodes which try to change the tracked files or swarms without being authenticated
+func (t *Tracker) requireAuthentication(conn *transport.TCPConnection) bool {
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if ok && nodeInfo.authenticated.Load() {
+		return true
+	}
+
+	logger.Warn("Unauthenticated node %s tried to change the tracked files or swarms. Closing connection", conn.RemoteAddr())
+	conn.Stop()
+
+	return false
 }
 
 func (t *Tracker) handlePublishFilePacket(packet *protocol.PublishFilePacket, conn *transport.TCPConnection) {
 	logger.Info("Publish file packet received from %s", conn.RemoteAddr())
 
-	// If file already exists
-	if t.files.Contains(packet.FileName) {
-		logger.Info("File %s published from %s already exists", packet.FileName, conn.RemoteAddr())
+	if !t.requireAuthentication(conn) {
+		return
+	}
 
-		aePacket := protocol.NewAlreadyExistsPacket(packet.FileName)
-		conn.EnqueuePacket(&aePacket)
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if !ok {
 		return
 	}
 
-	// Add file to the tracker
-	file := NewTrackedFile(packet.FileName, packet.FileSize, packet.FileHash, packet.ChunkHashes)
-	t.files.Put(packet.FileName, &file)
+	if len(packet.Files) != 0 {
+		err := multifile.ValidateManifest(packet.Files, packet.FileSize)
+		if err != nil {
+			logger.Warn("Directory %s published from %s has an invalid manifest: %v", packet.FileName, conn.RemoteAddr(), err)
+			return
+		}
+	}
 
-	// Add file to the node's list of files
-	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
-	if ok {
-		nodeInfo.files.Put(packet.FileName, protocol.NewCheckedBitfield(len(packet.ChunkHashes)))
+	if !validChunkHashes(packet) {
+		logger.Warn("File %s published from %s has invalid chunk hashes", packet.FileName, conn.RemoteAddr())
+		return
 	}
 
+	bitfield := protocol.NewCheckedBitfield(int(utils.NumberOfChunks(packet.FileSize)))
+
+	// Files published in a federated tracker are also checked, so the network never tracks two different
+	// contents with the same hash
+	if file, exists := t.getFile(packet.FileHash); exists {
+		// The same hash with a different size or chunks is a conflict
+		if !file.HasSameContent(packet.FileSize, packet.FileHash, packet.HashAlgorithm, packet.HashMode, packet.ChunkHashes, packet.MerkleRoot, packet.Files) {
+			logger.Info("File %s published from %s conflicts with the existing one", packet.FileName, conn.RemoteAddr())
+
+			cPacket := protocol.NewConflictPacket(packet.FileName, packet.FileHash)
+			conn.EnqueuePacket(&cPacket)
+			return
+		}
+
+		current, seeding := nodeInfo.files.Get(packet.FileHash)
+		if seeding && bytes.Equal(current, bitfield) {
+			logger.Info("File %s published from %s is already being seeded by it", packet.FileName, conn.RemoteAddr())
+
+			aePacket := protocol.NewAlreadyExistsPacket(packet.FileName, packet.FileHash)
+			conn.EnqueuePacket(&aePacket)
+			return
+		}
+
+		// The same content is already tracked, so the node joins as a seeder
+		logger.Info("Node %s joined file %s as a seeder", nodeInfo.name, packet.FileName)
+	} else {
+		// Add file to the tracker
+		file := NewTrackedFile(packet.FileName, packet.FileSize, packet.FileHash, packet.HashAlgorithm, packet.HashMode, packet.ChunkHashes, packet.MerkleRoot, packet.Files, nodeInfo.name)
+		t.store.PutFile(file)
+		t.files.Put(packet.FileHash, &file)
+	}
+
+	// Add file to the node's list of files
+	t.updateBitfield(nodeInfo, packet.FileHash, bitfield)
+
 	// Send response back to the node
-	pfsPacket := protocol.NewPublishFileSuccessPacket(packet.FileName)
+	pfsPacket := protocol.NewPublishFileSuccessPacket(packet.FileName, packet.FileHash)
 	conn.EnqueuePacket(&pfsPacket)
 }
 
+func (t *Tracker) handlePublishFilesPacket(packet *protocol.PublishFilesPacket, conn *transport.TCPConnection) {
+	logger.Info("Publish files packet with %d files received from %s", len(packet.Files), conn.RemoteAddr())
+
+	for i := range packet.Files {
+		t.handlePublishFilePacket(&packet.Files[i], conn)
+	}
+}
+
+// Returns whether the packet has a hash for every chunk of the file in flat hash mode, or only the merkle root
+// in merkle hash mode, with the digest size of a supported hash algorithm
+func validChunkHashes(packet *protocol.PublishFilePacket) bool {
+	hasher, err := utils.HasherFromAlgorithm(packet.HashAlgorithm)
+	if err != nil {
+		return false
+	}
+
+	switch packet.HashMode {
+	case protocol.HashModeFlat:
+		if uint64(len(packet.ChunkHashes)) != utils.NumberOfChunks(packet.FileSize) {
+			return false
+		}
+
+		for _, chunkHash := range packet.ChunkHashes {
+			if len(chunkHash) != hasher.Size() {
+				return false
+			}
+		}
+
+		return true
+	case protocol.HashModeMerkle:
+		return len(packet.ChunkHashes) == 0 && len(packet.MerkleRoot) == hasher.Size()
+	default:
+		return false
+	}
+}
+
 func (t *Tracker) handleRequestFilePacket(packet *protocol.RequestFilePacket, conn *transport.TCPConnection) {
 	logger.Info("Request file packet received from %s", conn.RemoteAddr())
 
-	if file, ok := t.files.Get(packet.FileName); ok {
-		var names []string
-		var ports []uint16
-		var bitfields []protocol.Bitfield
+	files := t.findFiles(packet.FileName, packet.FileHash)
+	switch len(files) {
+	case 0:
+		logger.Info("File %s requested from %s does not exist", packet.FileName, conn.RemoteAddr())
 
-		t.nodes.ForEach(func(_ string, node *NodeInfo) {
-			if bitfield, exists := node.files.Get(packet.FileName); exists {
-				names = append(names, node.name)
-				ports = append(ports, node.udpPort)
-				bitfields = append(bitfields, bitfield)
-			}
-		})
+		nfPacket := protocol.NewNotFoundPacket(packet.FileName, packet.FileHash)
+		conn.EnqueuePacket(&nfPacket)
+	case 1:
+		file := files[0]
+		names, ports, bitfields := t.swarm(file.FileHash)
 
 		// Send file name, hash and chunks hashes
-		anPacket := protocol.NewAnswerFileWithNodesPacket(file.FileName, file.FileSize, file.FileHash, file.ChunkHashes, names, ports, bitfields)
+		anPacket := protocol.NewAnswerFileWithNodesPacket(file.FileName, file.FileSize, file.FileHash, file.HashAlgorithm, file.HashMode, file.ChunkHashes, file.MerkleRoot, file.Files, names, ports, bitfields)
 		conn.EnqueuePacket(&anPacket)
-	} else {
-		logger.Info("File %s requested from %s does not exist", packet.FileName, conn.RemoteAddr())
+	default:
+		logger.Info("File name %s requested from %s matches %d files", packet.FileName, conn.RemoteAddr(), len(files))
 
-		nfPacket := protocol.NewNotFoundPacket(packet.FileName)
-		conn.EnqueuePacket(&nfPacket)
+		hashes := make([][20]byte, 0, len(files))
+		for _, file := range files {
+			hashes = append(hashes, file.FileHash)
+		}
+
+		afPacket := protocol.NewAmbiguousFilePacket(packet.FileName, hashes)
+		conn.EnqueuePacket(&afPacket)
 	}
 }
 
 func (t *Tracker) handleUpdateFilePacket(packet *protocol.UpdateFilePacket, conn *transport.TCPConnection) {
 	logger.Info("Update file packet received from %s", conn.RemoteAddr())
 
-	if file, ok := t.files.Get(packet.FileName); ok {
-		var ipAddrs []string
-		var ports []uint16
-		var bitfields []protocol.Bitfield
-
-		t.nodes.ForEach(func(_ string, node *NodeInfo) {
-			if bitfield, exists := node.files.Get(packet.FileName); exists {
-				ipAddrs = append(ipAddrs, node.name)
-				ports = append(ports, node.udpPort)
-				bitfields = append(bitfields, bitfield)
-			}
-		})
+	if _, ok := t.getFile(packet.FileHash); ok {
+		names, ports, bitfields := t.swarm(packet.FileHash)
 
-		// Send file name, hash and chunks hashes
-		anPacket := protocol.NewAnswerNodesPacket(file.FileName, ipAddrs, ports, bitfields)
+		anPacket := protocol.NewAnswerNodesPacket(packet.FileHash, names, ports, bitfields)
 		conn.EnqueuePacket(&anPacket)
 	}
 }
@@ -113,31 +291,102 @@ func (t *Tracker) handleUpdateFilePacket(packet *protocol.UpdateFilePacket, conn
 func (t *Tracker) handleRemoveFilePacket(packet *protocol.RemoveFilePacket, conn *transport.TCPConnection) {
 	logger.Info("Remove file packet received from %s", conn.RemoteAddr())
 
-	if _, ok := t.files.Get(packet.FileName); ok {
-		t.files.Delete(packet.FileName)
-
-		// Remove file from the node's list of files
-		nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
-		if ok {
-			nodeInfo.files.Delete(packet.FileName)
-		}
+	if !t.requireAuthentication(conn) {
+		return
+	}
 
-		rfsPacket := protocol.NewRemoveFileSuccessPacket(packet.FileName)
-		conn.EnqueuePacket(&rfsPacket)
-	} else {
-		logger.Info("File %s requested to be removed from %s does not exist", packet.FileName, conn.RemoteAddr())
+	file, ok := t.files.Get(packet.FileHash)
+	if !ok {
+		logger.Info("File %x requested to be removed from %s does not exist", packet.FileHash, conn.RemoteAddr())
 
-		nfPacket := protocol.NewNotFoundPacket(packet.FileName)
+		nfPacket := protocol.NewNotFoundPacket("", packet.FileHash)
 		conn.EnqueuePacket(&nfPacket)
+		return
 	}
+
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if !ok {
+		return
+	}
+
+	switch {
+	case file.Owner == nodeInfo.name || t.admins[nodeInfo.name]:
+		// The publisher (or an admin) removes the file from the whole network
+		t.removeFile(packet.FileHash)
+		logger.Info("File %s removed from the network by %s", file.FileName, nodeInfo.name)
+	case nodeInfo.files.Contains(packet.FileHash):
+		// Any other node only stops seeding the file
+		t.store.DeleteBitfield(nodeInfo.name, packet.FileHash)
+		nodeInfo.files.Delete(packet.FileHash)
+		logger.Info("Node %s stopped seeding file %s", nodeInfo.name, file.FileName)
+	default:
+		logger.Info("Node %s is not allowed to remove file %s", nodeInfo.name, file.FileName)
+
+		pdPacket := protocol.NewPermissionDeniedPacket(file.FileName, file.FileHash)
+		conn.EnqueuePacket(&pdPacket)
+		return
+	}
+
+	rfsPacket := protocol.NewRemoveFileSuccessPacket(file.FileName, file.FileHash)
+	conn.EnqueuePacket(&rfsPacket)
 }
 
 func (t *Tracker) handlePublishChunkPacket(packet *protocol.UpdateChunksPacket, conn *transport.TCPConnection) {
 	logger.Info("Publish chunk packet received from %s", conn.RemoteAddr())
 
-	// Update node's bitfield
+	if !t.requireAuthentication(conn) {
+		return
+	}
+
+	// Update node's bitfield, also for files only published in a federated tracker
 	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
-	if ok {
-		nodeInfo.files.Put(packet.FileName, packet.Bitfield)
+	if _, exists := t.getFile(packet.FileHash); ok && exists {
+		t.updateBitfield(nodeInfo, packet.FileHash, packet.Bitfield)
 	}
 }
+
+func (t *Tracker) handleSearchPacket(packet *protocol.SearchPacket, conn *transport.TCPConnection) {
+	logger.Info("Search packet received from %s", conn.RemoteAddr())
+
+	results := t.searchFiles(packet.Pattern)
+
+	srPacket := protocol.NewSearchResultsPacket(packet.Pattern, results)
+	conn.EnqueuePacket(&srPacket)
+}
+
+func (t *Tracker) handleListFilesPacket(_ *protocol.ListFilesPacket, conn *transport.TCPConnection) {
+	logger.Info("List files packet received from %s", conn.RemoteAddr())
+
+	flPacket := protocol.NewFileListPacket(t.listFiles())
+	conn.EnqueuePacket(&flPacket)
+}
+
+func (t *Tracker) handleRequestPeersPacket(packet *protocol.RequestPeersPacket, conn *transport.TCPConnection) {
+	logger.Info("Request peers packet received from %s", conn.RemoteAddr())
+
+	files := t.findFiles(packet.FileName, packet.FileHash)
+	switch len(files) {
+	case 0:
+		nfPacket := protocol.NewNotFoundPacket(packet.FileName, packet.FileHash)
+		conn.EnqueuePacket(&nfPacket)
+	case 1:
+		file := files[0]
+		names, ports, bitfields := t.swarm(file.FileHash)
+
+		pPacket := protocol.NewPeersPacket(file.FileName, file.FileHash, uint32(file.NumberOfChunks()), names, ports, bitfields)
+		conn.EnqueuePacket(&pPacket)
+	default:
+		hashes := make([][20]byte, 0, len(files))
+		for _, file := range files {
+			hashes = append(hashes, file.FileHash)
+		}
+
+		afPacket := protocol.NewAmbiguousFilePacket(packet.FileName, hashes)
+		conn.EnqueuePacket(&afPacket)
+	}
+}
+
+func (t *Tracker) handleHeartbeatPacket(_ *protocol.HeartbeatPacket, conn *transport.TCPConnection) {
+	ackPacket := protocol.NewHeartbeatAckPacket()
+	conn.EnqueuePacket(&ackPacket)
+}
@@ -4,6 +4,7 @@ import (
 	"PessiTorrent/internal/config"
 	"PessiTorrent/internal/logger"
 	"flag"
+	"strings"
 )
 
 func main() {
@@ -13,10 +14,36 @@ func main() {
 	}
 
 	port := cfg.Tracker.Port
+	storage := cfg.Tracker.Storage
+	if storage == "" {
+		storage = DefaultStorageDirectory
+	}
+
+	nodeTimeout := cfg.Tracker.NodeTimeout
+	if nodeTimeout <= 0 {
+		nodeTimeout = DefaultNodeTimeout
+	}
 
 	flag.UintVar(&port, "p", port, "Port to listen on")
+	flag.StringVar(&storage, "s", storage, "Directory where the tracker state is stored")
+	flag.DurationVar(&nodeTimeout, "t", nodeTimeout, "Time without packets after which a node is evicted")
+
+	federation := strings.Join(cfg.Tracker.Federation, ",")
+	flag.StringVar(&federation, "f", federation, "Addresses of the trackers to federate with, separated by commas")
 	flag.Parse()
 
-	tracker := NewTracker(uint16(port))
+	var federationAddrs []string
+	if federation != "" {
+		federationAddrs = strings.Split(federation, ",")
+	}
+
+	store, err := OpenStore(storage)
+	if err != nil {
+		logger.Error("Failed to open storage on %s: %s", storage, err)
+		return
+	}
+	defer store.Close()
+
+	tracker := NewTracker(uint16(port), store, cfg.Tracker.Keys, cfg.Tracker.Admins, nodeTimeout, federationAddrs)
 	tracker.Start()
 }
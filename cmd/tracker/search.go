@@ -0,0 +1,95 @@
+package main
+
+import (
+	"PessiTorrent/internal/protocol"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Returns whether the file name matches the pattern, ignoring case.
+// Patterns with wildcards are matched as globs, any other pattern as a substring.
+func matchesPattern(fileName string, pattern string) bool {
+	fileName = strings.ToLower(fileName)
+	pattern = strings.ToLower(pattern)
+
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, err := path.Match(pattern, fileName)
+		return err == nil && matched
+	}
+
+	return strings.Contains(fileName, pattern)
+}
+
+// Returns every tracked file (also in federated trackers) whose name matches the pattern, sorted by name
+func (t *Tracker) searchFiles(pattern string) []protocol.SearchResult {
+	results := make([]protocol.SearchResult, 0)
+
+	for _, file := range t.allFiles() {
+		if !matchesPattern(file.FileName, pattern) {
+			continue
+		}
+
+		seeders, leechers := t.countPeers(file)
+		results = append(results, protocol.SearchResult{
+			FileName: file.FileName,
+			FileHash: file.FileHash,
+			FileSize: file.FileSize,
+			Seeders:  seeders,
+			Leechers: leechers,
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].FileName < results[j].FileName
+	})
+
+	return results
+}
+
+// Returns the number of connected nodes with all chunks and with only some chunks of the file
+func (t *Tracker) countPeers(file *TrackedFile) (uint32, uint32) {
+	var seeders, leechers uint32
+	numberOfChunks := file.NumberOfChunks()
+
+	_, _, bitfields := t.swarm(file.FileHash)
+	for _, bitfield := range bitfields {
+		if protocol.CountSetBits(bitfield, numberOfChunks) == numberOfChunks {
+			seeders++
+		} else {
+			leechers++
+		}
+	}
+
+	return seeders, leechers
+}
+
+// Returns every tracked file (also in federated trackers), sorted by name, with the number of chunks held by the connected nodes
+func (t *Tracker) listFiles() []protocol.FileListEntry {
+	files := t.allFiles()
+	entries := make([]protocol.FileListEntry, 0, len(files))
+
+	for _, file := range files {
+		numberOfChunks := file.NumberOfChunks()
+
+		var copies uint64
+		_, _, bitfields := t.swarm(file.FileHash)
+		for _, bitfield := range bitfields {
+			copies += uint64(protocol.CountSetBits(bitfield, numberOfChunks))
+		}
+
+		entries = append(entries, protocol.FileListEntry{
+			FileName:       file.FileName,
+			FileHash:       file.FileHash,
+			FileSize:       file.FileSize,
+			NumberOfChunks: uint32(numberOfChunks),
+			ChunkCopies:    copies,
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FileName < entries[j].FileName
+	})
+
+	return entries
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/transport"
+	"testing"
+)
+
+func TestMatchesPattern(t *testing.T) {
+	tests := []struct {
+		fileName string
+		pattern  string
+		want     bool
+	}{
+		{"holidays.jpg", "*.jpg", true},
+		{"holidays.jpg", "*.png", false},
+		{"Holidays.JPG", "*.jpg", true},
+		{"holidays.jpg", "holiday?.jpg", true},
+		{"holidays.jpg", "holiday?.png", false},
+		{"holidays.jpg", "h?l*", true},
+		{"holidays.jpg", "days", true},
+		{"holidays.jpg", "DAYS", true},
+		{"holidays.jpg", "nights", false},
+		{"holidays.jpg", "", true},
+		{"holidays.jpg", "[", false}, // Malformed globs match nothing
+	}
+
+	for _, test := range tests {
+		if got := matchesPattern(test.fileName, test.pattern); got != test.want {
+			t.Errorf("matchesPattern(%q, %q) = %v, want %v", test.fileName, test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestSearchFiles(t *testing.T) {
+	tracker := NewTracker(0, nil, nil, nil, DefaultNodeTimeout, nil)
+
+	// One chunk each, so a single bit tells seeders from leechers
+	for i, fileName := range []string{"notes.txt", "photo.jpg", "music.mp3", "photo-2.jpg"} {
+		file := NewTrackedFile(fileName, 10, [20]byte{byte(i + 1)}, protocol.HashAlgorithmSHA1, protocol.HashModeFlat, [][]byte{{}}, nil, nil, "owner")
+		tracker.files.Put(file.FileHash, &file)
+	}
+
+	seeder := NewNodeInfo(transport.TCPConnection{}, 8081, "seeder")
+	seeder.files.Put([20]byte{2}, protocol.NewCheckedBitfield(1))
+	tracker.nodes.Put("seeder", &seeder)
+
+	leecher := NewNodeInfo(transport.TCPConnection{}, 8082, "leecher")
+	leecher.files.Put([20]byte{2}, protocol.EncodeBitField([]bool{false}))
+	tracker.nodes.Put("leecher", &leecher)
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.jpg", []string{"photo-2.jpg", "photo.jpg"}},
+		{"photo?2.jpg", []string{"photo-2.jpg"}},
+		{"O", []string{"notes.txt", "photo-2.jpg", "photo.jpg"}},
+		{"*.pdf", []string{}},
+	}
+
+	for _, test := range tests {
+		results := tracker.searchFiles(test.pattern)
+
+		names := make([]string, 0, len(results))
+		for _, result := range results {
+			names = append(names, result.FileName)
+		}
+
+		if len(names) != len(test.want) {
+			t.Errorf("searchFiles(%q) = %v, want %v", test.pattern, names, test.want)
+			continue
+		}
+		for i := range names {
+			if names[i] != test.want[i] {
+				t.Errorf("searchFiles(%q) = %v, want %v", test.pattern, names, test.want)
+				break
+			}
+		}
+	}
+
+	results := tracker.searchFiles("photo.jpg")
+	if len(results) != 1 || results[0].Seeders != 1 || results[0].Leechers != 1 {
+		t.Errorf("Expected photo.jpg to have 1 seeder and 1 leecher, got %v", results)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/wal"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	SnapshotFileName = "tracker.snapshot"
+	LogFileName      = "tracker.wal"
+
+	// Number of entries in the write-ahead log after which it is compacted into a new snapshot
+	MaxLogEntries = 1000
+
+	// Layout version of StoreRecord, to be increased whenever it (or TrackedFile) changes
+	StoreVersion = 1
+)
+
+const (
+	PutFileRecord = iota
+	DeleteFileRecord
+	PutBitfieldRecord
+	DeleteBitfieldRecord
+	DeleteNodeRecord
+)
+
+// StoreRecord is a single entry of the tracker's write-ahead log and snapshot
+type StoreRecord struct {
+	Type     uint8
+	Node     string
+	File     TrackedFile
+	Bitfield protocol.Bitfield
+}
+
+// Store persists the tracked files and the bitfields of each node (by name) to disk.
+// Every change is appended to a write-ahead log, which is periodically compacted into a snapshot.
+type Store struct {
+	sync.Mutex
+	snapshotPath string
+	log          *wal.Log[StoreRecord]
+
+	// Current state, used to write snapshots
+	files     map[[20]byte]TrackedFile
+	bitfields map[string]map[[20]byte]protocol.Bitfield // Node name -> File hash -> Bitfield
+}
+
+func OpenStore(directory string) (*Store, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := wal.Open[StoreRecord](filepath.Join(directory, LogFileName), StoreVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{
+		snapshotPath: filepath.Join(directory, SnapshotFileName),
+		log:          log,
+		files:        make(map[[20]byte]TrackedFile),
+		bitfields:    make(map[string]map[[20]byte]protocol.Bitfield),
+	}
+
+	err = wal.ReadFile(store.snapshotPath, StoreVersion, store.apply)
+	if err != nil {
+		return nil, err
+	}
+
+	err = log.Replay(store.apply)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Files returns every tracked file loaded from disk
+func (s *Store) Files() []TrackedFile {
+	s.Lock()
+	defer s.Unlock()
+
+	files := make([]TrackedFile, 0, len(s.files))
+	for _, file := range s.files {
+		files = append(files, file)
+	}
+
+	return files
+}
+
+// Bitfields returns the bitfields loaded from disk for each node
+func (s *Store) Bitfields() map[string]map[[20]byte]protocol.Bitfield {
+	s.Lock()
+	defer s.Unlock()
+
+	bitfields := make(map[string]map[[20]byte]protocol.Bitfield, len(s.bitfields))
+	for node, files := range s.bitfields {
+		bitfields[node] = make(map[[20]byte]protocol.Bitfield, len(files))
+		for fileHash, bitfield := range files {
+			bitfields[node][fileHash] = bitfield
+		}
+	}
+
+	return bitfields
+}
+
+func (s *Store) PutFile(file TrackedFile) {
+	s.write(StoreRecord{Type: PutFileRecord, File: file})
+}
+
+func (s *Store) DeleteFile(fileHash [20]byte) {
+	s.write(StoreRecord{Type: DeleteFileRecord, File: TrackedFile{FileHash: fileHash}})
+}
+
+func (s *Store) PutBitfield(node string, fileHash [20]byte, bitfield protocol.Bitfield) {
+	s.write(StoreRecord{Type: PutBitfieldRecord, Node: node, File: TrackedFile{FileHash: fileHash}, Bitfield: bitfield})
+}
+
+func (s *Store) DeleteBitfield(node string, fileHash [20]byte) {
+	s.write(StoreRecord{Type: DeleteBitfieldRecord, Node: node, File: TrackedFile{FileHash: fileHash}})
+}
+
+func (s *Store) DeleteNode(node string) {
+	s.write(StoreRecord{Type: DeleteNodeRecord, Node: node})
+}
+
+func (s *Store) Close() error {
+	return s.log.Close()
+}
+
+// Appends the record to the log before applying it to the in-memory state
+func (s *Store) write(record StoreRecord) {
+	s.Lock()
+	defer s.Unlock()
+
+	err := s.log.Append(&record)
+	if err != nil {
+		logger.Error("Error writing to the tracker log: %v", err)
+		return
+	}
+
+	s.apply(record)
+
+	if s.log.Len() >= MaxLogEntries {
+		err = s.compact()
+		if err != nil {
+			logger.Error("Error compacting the tracker log: %v", err)
+		}
+	}
+}
+
+func (s *Store) apply(record StoreRecord) {
+	switch record.Type {
+	case PutFileRecord:
+		s.files[record.File.FileHash] = record.File
+	case DeleteFileRecord:
+		delete(s.files, record.File.FileHash)
+	case PutBitfieldRecord:
+		if _, ok := s.bitfields[record.Node]; !ok {
+			s.bitfields[record.Node] = make(map[[20]byte]protocol.Bitfield)
+		}
+		s.bitfields[record.Node][record.File.FileHash] = record.Bitfield
+	case DeleteBitfieldRecord:
+		delete(s.bitfields[record.Node], record.File.FileHash)
+		if len(s.bitfields[record.Node]) == 0 {
+			delete(s.bitfields, record.Node)
+		}
+	case DeleteNodeRecord:
+		delete(s.bitfields, record.Node)
+	default:
+		logger.Warn("Unknown tracker log record type: %d", record.Type)
+	}
+}
+
+// Writes the current state to a new snapshot and discards the log
+func (s *Store) compact() error {
+	records := make([]StoreRecord, 0, len(s.files))
+
+	for _, file := range s.files {
+		records = append(records, StoreRecord{Type: PutFileRecord, File: file})
+	}
+
+	for node, files := range s.bitfields {
+		for fileHash, bitfield := range files {
+			records = append(records, StoreRecord{Type: PutBitfieldRecord, Node: node, File: TrackedFile{FileHash: fileHash}, Bitfield: bitfield})
+		}
+	}
+
+	err := wal.WriteFile(s.snapshotPath, StoreVersion, records)
+	if err != nil {
+		return err
+	}
+
+	return s.log.Truncate()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
+	"PessiTorrent/internal/transport"
+)
+
+// Stores the bitfield of a file sent by the node, and pushes the chunks it did not have before
+// to the other nodes subscribed to the file
+func (t *Tracker) updateBitfield(nodeInfo *NodeInfo, fileHash [20]byte, bitfield protocol.Bitfield) {
+	previous, _ := nodeInfo.files.Get(fileHash)
+
+	t.store.PutBitfield(nodeInfo.name, fileHash, bitfield)
+	nodeInfo.files.Put(fileHash, bitfield)
+
+	chunks := newChunks(previous, bitfield)
+	if len(chunks) == 0 {
+		return
+	}
+
+	var subscribers []*NodeInfo
+	t.nodes.ForEach(func(_ string, subscriber *NodeInfo) {
+		if subscriber != nodeInfo && subscriber.subscriptions.Contains(fileHash) {
+			subscribers = append(subscribers, subscriber)
+		}
+	})
+
+	// Packets are enqueued outside of the lock, since a stalled subscriber would block every other node
+	packet := protocol.NewSwarmDeltaPacket(fileHash, nodeInfo.name, nodeInfo.udpPort, chunks)
+	for _, subscriber := range subscribers {
+		subscriber.conn.EnqueuePacket(&packet)
+	}
+}
+
+// Returns the indexes of the chunks in the current bitfield which are not in the previous one
+func newChunks(previous protocol.Bitfield, current protocol.Bitfield) []uint16 {
+	had := protocol.DecodeBitField(previous)
+	has := protocol.DecodeBitField(current)
+
+	chunks := make([]uint16, 0)
+	for i, hasChunk := range has {
+		if hasChunk && (i >= len(had) || !had[i]) {
+			chunks = append(chunks, uint16(i))
+		}
+	}
+
+	return chunks
+}
+
+func (t *Tracker) handleSubscribeFilePacket(packet *protocol.SubscribeFilePacket, conn *transport.TCPConnection) {
+	logger.Info("Subscribe file packet received from %s", conn.RemoteAddr())
+
+	if !t.requireAuthentication(conn) {
+		return
+	}
+
+	nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String())
+	if _, exists := t.getFile(packet.FileHash); ok && exists {
+		nodeInfo.subscriptions.Put(packet.FileHash, true)
+	}
+}
+
+func (t *Tracker) handleUnsubscribeFilePacket(packet *protocol.UnsubscribeFilePacket, conn *transport.TCPConnection) {
+	logger.Info("Unsubscribe file packet received from %s", conn.RemoteAddr())
+
+	if nodeInfo, ok := t.nodes.Get(conn.RemoteAddr().String()); ok {
+		nodeInfo.subscriptions.Delete(packet.FileHash)
+	}
+}
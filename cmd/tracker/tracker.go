@@ -2,33 +2,83 @@ package main
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/structures"
+	"PessiTorrent/internal/ticker"
 	"PessiTorrent/internal/transport"
 	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StaleNodeExpiration = 24 * time.Hour
+	ExpirationInterval  = 1 * time.Minute
+
+	DefaultNodeTimeout    = 30 * time.Second
+	LivenessCheckInterval = 5 * time.Second
+
+	DefaultStorageDirectory = "tracker-data"
 )
 
 type Tracker struct {
 	tcpPort  uint16
 	listener net.Listener
 
-	files structures.SynchronizedMap[string, *TrackedFile]
+	store  *Store
+	tck    ticker.Ticker
+	keys   map[string]string // Node name -> Pre-shared key
+	admins map[string]bool   // Names of the nodes allowed to remove any file
+
+	livenessTck   ticker.Ticker
+	federationTck ticker.Ticker
+	nodeTimeout   time.Duration // Time without packets after which a node is evicted
+	evictions     atomic.Uint64 // Number of nodes evicted for being silent
+
+	files structures.SynchronizedMap[[20]byte, *TrackedFile] // File hash -> File
 	nodes structures.SynchronizedMap[string, *NodeInfo]
+	stale structures.SynchronizedMap[string, *StaleNode] // Node name -> Bitfields of a disconnected node
+
+	federationAddrs  []string                                                     // Addresses of the trackers to subscribe to
+	federation       structures.SynchronizedMap[string, *FederatedTracker]        // Connection address -> Tracker subscribed to
+	subscribers      structures.SynchronizedMap[string, *transport.TCPConnection] // Connection address -> Tracker subscribed to this one
+	federationDigest [20]byte                                                     // Digest of the last state pushed to the subscribed trackers
 
 	quitChannel chan struct{}
 }
 
-func NewTracker(port uint16) Tracker {
+func NewTracker(port uint16, store *Store, keys map[string]string, admins []string, nodeTimeout time.Duration, federationAddrs []string) Tracker {
+	adminSet := make(map[string]bool, len(admins))
+	for _, admin := range admins {
+		adminSet[admin] = true
+	}
+
 	return Tracker{
 		tcpPort: port,
-		files:   structures.NewSynchronizedMap[string, *TrackedFile](),
-		nodes:   structures.NewSynchronizedMap[string, *NodeInfo](),
+		store:   store,
+		keys:    keys,
+		admins:  adminSet,
+
+		nodeTimeout: nodeTimeout,
+
+		files: structures.NewSynchronizedMap[[20]byte, *TrackedFile](),
+		nodes: structures.NewSynchronizedMap[string, *NodeInfo](),
+		stale: structures.NewSynchronizedMap[string, *StaleNode](),
+
+		federationAddrs: federationAddrs,
+		federation:      structures.NewSynchronizedMap[string, *FederatedTracker](),
+		subscribers:     structures.NewSynchronizedMap[string, *transport.TCPConnection](),
 
 		quitChannel: make(chan struct{}),
 	}
 }
 
 func (t *Tracker) Start() {
+	t.loadStore()
+
 	go t.startTCP()
+	go t.startTicker()
+	go t.startFederation()
 
 	<-t.quitChannel
 }
@@ -38,6 +88,68 @@ func (t *Tracker) Stop() {
 	close(t.quitChannel)
 }
 
+// Loads the persisted state. Bitfields are kept as stale until their node reconnects.
+func (t *Tracker) loadStore() {
+	for _, file := range t.store.Files() {
+		file := file
+		t.files.Put(file.FileHash, &file)
+	}
+
+	for name, files := range t.store.Bitfields() {
+		staleNode := NewStaleNode(files)
+		t.stale.Put(name, &staleNode)
+	}
+
+	logger.Info("Loaded %d files and bitfields of %d nodes from storage", t.files.Len(), t.stale.Len())
+}
+
+func (t *Tracker) startTicker() {
+	tck := ticker.NewTicker(ExpirationInterval, t.expireStaleNodes)
+	tck.Start()
+	t.tck = tck
+
+	livenessTck := ticker.NewTicker(LivenessCheckInterval, t.evictSilentNodes)
+	livenessTck.Start()
+	t.livenessTck = livenessTck
+
+	federationTck := ticker.NewTicker(FederationSyncInterval, t.pushFederationState)
+	federationTck.Start()
+	t.federationTck = federationTck
+}
+
+// Closes the connection of the nodes which have not sent any packet for too long, such as half-open connections.
+// Their bitfields are then kept as stale, like for any other disconnected node.
+func (t *Tracker) evictSilentNodes() {
+	var silent []*NodeInfo
+
+	t.nodes.ForEach(func(_ string, nodeInfo *NodeInfo) {
+		if nodeInfo.SilentFor() > t.nodeTimeout {
+			silent = append(silent, nodeInfo)
+		}
+	})
+
+	// Connections are stopped outside of the lock, since removing the node locks the nodes again
+	for _, nodeInfo := range silent {
+		evictions := t.evictions.Add(1)
+		logger.Warn("Evicting node %s (%s) after %v without packets (%d nodes evicted so far)", nodeInfo.name, nodeInfo.conn.RemoteAddr(), nodeInfo.SilentFor().Round(time.Second), evictions)
+		nodeInfo.conn.Stop()
+	}
+}
+
+// Forgets the bitfields of nodes which have not reconnected for too long
+func (t *Tracker) expireStaleNodes() {
+	t.stale.Lock()
+	defer t.stale.Unlock()
+
+	for name, staleNode := range t.stale.M {
+		if time.Since(staleNode.since) > StaleNodeExpiration {
+			logger.Info("Bitfields of node %s expired", name)
+			t.store.DeleteNode(name)
+			delete(t.stale.M, name)
+		}
+	}
+}
+
 func (t *Tracker) startTCP() {
 	tcpAddr := net.TCPAddr{
 		IP:   net.IPv4zero,
@@ -67,10 +179,118 @@ func (t *Tracker) acceptConnections() {
 
 		conn := transport.NewTCPConnection(cn, t.HandlePackets, func() {
 			logger.Info("Node %s disconnected", cn.RemoteAddr())
-			t.nodes.Delete(cn.RemoteAddr().String())
+			t.removeNode(cn.RemoteAddr().String())
+			t.subscribers.Delete(cn.RemoteAddr().String())
 		})
 		logger.Info("Node %s connected", conn.RemoteAddr())
 
 		go conn.Start()
 	}
 }
+
+// Returns the file with the given hash or, if the hash is zero, every file with the given name
+func (t *Tracker) findFiles(fileName string, fileHash [20]byte) []*TrackedFile {
+	if fileHash != [20]byte{} {
+		if file, ok := t.getFile(fileHash); ok {
+			return []*TrackedFile{file}
+		}
+
+		return nil
+	}
+
+	var files []*TrackedFile
+	for _, file := range t.allFiles() {
+		if file.FileName == fileName {
+			files = append(files, file)
+		}
+	}
+
+	return files
+}
+
+// Returns the name, UDP port and bitfield of every node, connected to this tracker or a federated one,
+// which has chunks of the file
+func (t *Tracker) swarm(fileHash [20]byte) ([]string, []uint16, []protocol.Bitfield) {
+	var names []string
+	var ports []uint16
+	var bitfields []protocol.Bitfield
+
+	// Nodes connected to more than one tracker are only listed once
+	type nodeKey struct {
+		name string
+		port uint16
+	}
+	seen := make(map[nodeKey]bool)
+
+	t.nodes.ForEach(func(_ string, node *NodeInfo) {
+		if bitfield, exists := node.files.Get(fileHash); exists {
+			seen[nodeKey{node.name, node.udpPort}] = true
+			names = append(names, node.name)
+			ports = append(ports, node.udpPort)
+			bitfields = append(bitfields, bitfield)
+		}
+	})
+
+	t.federation.ForEach(func(_ string, federated *FederatedTracker) {
+		federated.Lock()
+		defer federated.Unlock()
+
+		for _, node := range federated.nodes {
+			key := nodeKey{node.Name, node.Port}
+			if seen[key] {
+				continue
+			}
+
+			for _, file := range node.Files {
+				if file.FileHash == fileHash {
+					seen[key] = true
+					names = append(names, node.Name)
+					ports = append(ports, node.Port)
+					bitfields = append(bitfields, file.Bitfield)
+					break
+				}
+			}
+		}
+	})
+
+	return names, ports, bitfields
+}
+
+// Stops tracking a file, removing it from the bitfields of every node
+func (t *Tracker) removeFile(fileHash [20]byte) {
+	t.store.DeleteFile(fileHash)
+	t.files.Delete(fileHash)
+
+	t.nodes.ForEach(func(_ string, nodeInfo *NodeInfo) {
+		if nodeInfo.files.Contains(fileHash) {
+			t.store.DeleteBitfield(nodeInfo.name, fileHash)
+			nodeInfo.files.Delete(fileHash)
+		}
+	})
+
+	t.stale.ForEach(func(name string, staleNode *StaleNode) {
+		if _, ok := staleNode.files[fileHash]; ok {
+			t.store.DeleteBitfield(name, fileHash)
+			delete(staleNode.files, fileHash)
+		}
+	})
+}
+
+// Removes a disconnected node, keeping its bitfields as stale until it reconnects
+func (t *Tracker) removeNode(addr string) {
+	nodeInfo, ok := t.nodes.Get(addr)
+	if !ok {
+		return
+	}
+	t.nodes.Delete(addr)
+
+	files := make(map[[20]byte]protocol.Bitfield)
+	nodeInfo.files.ForEach(func(fileHash [20]byte, bitfield protocol.Bitfield) {
+		files[fileHash] = bitfield
+	})
+
+	if len(files) != 0 {
+		staleNode := NewStaleNode(files)
+		t.stale.Put(nodeInfo.name, &staleNode)
+	}
+}
@@ -3,6 +3,7 @@ module PessiTorrent
 go 1.21.1
 
 require (
+	golang.org/x/crypto v0.17.0
 	golang.org/x/term v0.15.0
 	gopkg.in/yaml.v3 v3.0.1
 )
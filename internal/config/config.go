@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -18,12 +19,42 @@ type Config struct {
 	} `yaml:"dns"`
 
 	Tracker struct {
-		Host string `yaml:"host"`
-		Port uint   `yaml:"port"`
+		Host    string `yaml:"host"`
+		Port    uint   `yaml:"port"`
+		Storage string `yaml:"storage"`
+
+		// Pre-shared key of each node, by name. If empty, nodes are not authenticated.
+		Keys map[string]string `yaml:"keys"`
+
+		// Names of the nodes allowed to remove any file
+		Admins []string `yaml:"admins"`
+
+		// Time without any packet from a node after which it is evicted (e.g. "30s")
+		NodeTimeout time.Duration `yaml:"node_timeout"`
+
+		// Addresses ("host:port") of the trackers whose files and nodes are also advertised by this one
+		Federation []string `yaml:"federation"`
 	} `yaml:"tracker"`
 
 	Node struct {
-		Port uint `yaml:"port"`
+		Port uint   `yaml:"port"`
+		Key  string `yaml:"key"`
+
+		// Addresses ("host:port") of the trackers the node announces to. If empty, the tracker above is used.
+		Trackers []string `yaml:"trackers"`
+
+		// UDP addresses ("host:port") of the nodes used to join the DHT, used to find peers when no tracker is reachable
+		DHTBootstrap []string `yaml:"dht_bootstrap"`
+
+		// How the chunks of published files are verified: "flat" (default) or "merkle"
+		HashMode string `yaml:"hash_mode"`
+
+		// Hash algorithm of published files: "sha1" (default), "sha256" or "blake2b"
+		HashAlgorithm string `yaml:"hash_algorithm"`
+
+		// Maximum upload and download rates (e.g. "512KB/s"). If empty or 0, there is no limit.
+		UploadLimit   string `yaml:"upload_limit"`
+		DownloadLimit string `yaml:"download_limit"`
 	} `yaml:"node"`
 }
 
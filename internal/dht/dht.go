@@ -0,0 +1,318 @@
+package dht
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	IDLength   = 20 // Same length as the file hashes, which are the keys of the table
+	BucketSize = 8  // Maximum number of contacts per bucket (k)
+	Alpha      = 3  // Number of contacts queried at the same time during a lookup
+)
+
+// ID identifies a node in the DHT and the keys stored in it. Closeness is measured by the XOR of two IDs.
+type ID [IDLength]byte
+
+func NewRandomID() (ID, error) {
+	var id ID
+	_, err := rand.Read(id[:])
+	return id, err
+}
+
+// Distance returns the XOR distance between the IDs
+func (id ID) Distance(other ID) ID {
+	var distance ID
+	for i := range id {
+		distance[i] = id[i] ^ other[i]
+	}
+
+	return distance
+}
+
+// Closer reports whether a is closer to the ID than b
+func (id ID) Closer(a ID, b ID) bool {
+	da := id.Distance(a)
+	db := id.Distance(b)
+	return bytes.Compare(da[:], db[:]) < 0
+}
+
+// Returns the index of the bucket the other ID belongs to, which is the length of the prefix shared with the ID,
+// or -1 if the IDs are equal
+func (id ID) bucketIndex(other ID) int {
+	distance := id.Distance(other)
+	for i, b := range distance {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+
+	return -1
+}
+
+// Contact is a node of the DHT, reachable at a UDP address
+type Contact struct {
+	ID   ID
+	Addr string
+}
+
+// RoutingTable keeps the contacts known by a node in buckets by their distance to it.
+// Buckets are ordered from least to most recently seen and, when full, keep the oldest contacts,
+// since nodes which have been up for long are the most likely to stay up.
+type RoutingTable struct {
+	sync.Mutex
+	self    ID
+	buckets [IDLength * 8][]Contact
+}
+
+func NewRoutingTable(self ID) *RoutingTable {
+	return &RoutingTable{
+		self: self,
+	}
+}
+
+// Update records that the contact was just seen, and reports whether it was added to the table
+func (rt *RoutingTable) Update(contact Contact) bool {
+	index := rt.self.bucketIndex(contact.ID)
+	if index < 0 {
+		return false
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+
+	bucket := rt.buckets[index]
+	for i, c := range bucket {
+		if c.ID == contact.ID {
+			// Moved to the end, as the most recently seen
+			bucket = append(bucket[:i], bucket[i+1:]...)
+			rt.buckets[index] = append(bucket, contact)
+			return false
+		}
+	}
+
+	if len(bucket) >= BucketSize {
+		return false
+	}
+
+	rt.buckets[index] = append(bucket, contact)
+	return true
+}
+
+// Remove forgets the contact, e.g. after it did not answer
+func (rt *RoutingTable) Remove(id ID) {
+	index := rt.self.bucketIndex(id)
+	if index < 0 {
+		return
+	}
+
+	rt.Lock()
+	defer rt.Unlock()
+
+	bucket := rt.buckets[index]
+	for i, c := range bucket {
+		if c.ID == id {
+			rt.buckets[index] = append(bucket[:i], bucket[i+1:]...)
+			return
+		}
+	}
+}
+
+// Closest returns up to count contacts, sorted by their distance to the target
+func (rt *RoutingTable) Closest(target ID, count int) []Contact {
+	rt.Lock()
+	contacts := make([]Contact, 0)
+	for _, bucket := range rt.buckets {
+		contacts = append(contacts, bucket...)
+	}
+	rt.Unlock()
+
+	sortByDistance(target, contacts)
+	if len(contacts) > count {
+		contacts = contacts[:count]
+	}
+
+	return contacts
+}
+
+// ContainsAddr reports whether there is a contact with the given address
+func (rt *RoutingTable) ContainsAddr(addr string) bool {
+	rt.Lock()
+	defer rt.Unlock()
+
+	for _, bucket := range rt.buckets {
+		for _, c := range bucket {
+			if c.Addr == addr {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Len returns the number of contacts in the table
+func (rt *RoutingTable) Len() int {
+	rt.Lock()
+	defer rt.Unlock()
+
+	length := 0
+	for _, bucket := range rt.buckets {
+		length += len(bucket)
+	}
+
+	return length
+}
+
+func sortByDistance(target ID, contacts []Contact) {
+	sort.Slice(contacts, func(i, j int) bool {
+		return target.Closer(contacts[i].ID, contacts[j].ID)
+	})
+}
+
+// PeerStore keeps the addresses of the peers which announced to have chunks of a file, until they expire
+type PeerStore struct {
+	sync.Mutex
+	ttl   time.Duration
+	peers map[ID]map[string]time.Time // Key -> Peer address -> Time it was announced
+}
+
+func NewPeerStore(ttl time.Duration) *PeerStore {
+	return &PeerStore{
+		ttl:   ttl,
+		peers: make(map[ID]map[string]time.Time),
+	}
+}
+
+// Add records that the peer has chunks of the file with the given key
+func (ps *PeerStore) Add(key ID, addr string) {
+	ps.Lock()
+	defer ps.Unlock()
+
+	if ps.peers[key] == nil {
+		ps.peers[key] = make(map[string]time.Time)
+	}
+	ps.peers[key][addr] = time.Now()
+}
+
+// Get returns the addresses of the peers, which have not expired, with chunks of the file with the given key
+func (ps *PeerStore) Get(key ID) []string {
+	ps.Lock()
+	defer ps.Unlock()
+
+	addrs := make([]string, 0)
+	for addr, announced := range ps.peers[key] {
+		if time.Since(announced) < ps.ttl {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+// Expire forgets the peers which have not announced themselves again for too long
+func (ps *PeerStore) Expire() {
+	ps.Lock()
+	defer ps.Unlock()
+
+	for key, peers := range ps.peers {
+		for addr, announced := range peers {
+			if time.Since(announced) >= ps.ttl {
+				delete(peers, addr)
+			}
+		}
+
+		if len(peers) == 0 {
+			delete(ps.peers, key)
+		}
+	}
+}
+
+// Lookup keeps the state of an iterative lookup of the contacts closest to a target.
+// Contacts are queried, closest first, until every one of the k closest contacts known has answered or failed.
+// It is not safe for concurrent use.
+type Lookup struct {
+	target   ID
+	closest  []Contact // Closest contacts known, sorted by distance
+	seen     map[ID]bool
+	queried  map[ID]bool
+	answered map[ID]bool
+}
+
+func NewLookup(target ID, initial []Contact) *Lookup {
+	l := &Lookup{
+		target:   target,
+		closest:  make([]Contact, 0),
+		seen:     make(map[ID]bool),
+		queried:  make(map[ID]bool),
+		answered: make(map[ID]bool),
+	}
+	l.Add(initial)
+
+	return l
+}
+
+// Next returns up to count contacts to query, among the k closest not queried yet
+func (l *Lookup) Next(count int) []Contact {
+	next := make([]Contact, 0, count)
+	for i := 0; i < len(l.closest) && i < BucketSize && len(next) < count; i++ {
+		contact := l.closest[i]
+		if !l.queried[contact.ID] {
+			l.queried[contact.ID] = true
+			next = append(next, contact)
+		}
+	}
+
+	return next
+}
+
+// Answered records the contacts sent by a contact which answered a query
+func (l *Lookup) Answered(from ID, contacts []Contact) {
+	l.answered[from] = true
+	l.Add(contacts)
+}
+
+// Failed removes a contact which did not answer a query
+func (l *Lookup) Failed(id ID) {
+	for i, contact := range l.closest {
+		if contact.ID == id {
+			l.closest = append(l.closest[:i], l.closest[i+1:]...)
+			return
+		}
+	}
+}
+
+// Add adds contacts not seen before to the lookup
+func (l *Lookup) Add(contacts []Contact) {
+	for _, contact := range contacts {
+		if l.seen[contact.ID] {
+			continue
+		}
+
+		l.seen[contact.ID] = true
+		l.closest = append(l.closest, contact)
+	}
+
+	sortByDistance(l.target, l.closest)
+}
+
+// Closest returns up to k contacts which answered, sorted by their distance to the target
+func (l *Lookup) Closest() []Contact {
+	closest := make([]Contact, 0, BucketSize)
+	for _, contact := range l.closest {
+		if len(closest) == BucketSize {
+			break
+		}
+
+		if l.answered[contact.ID] {
+			closest = append(closest, contact)
+		}
+	}
+
+	return closest
+}
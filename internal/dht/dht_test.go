@@ -0,0 +1,151 @@
+package dht
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDistance(t *testing.T) {
+	a := ID{0x0F}
+	b := ID{0xF0, 1}
+
+	distance := a.Distance(b)
+	if distance != (ID{0xFF, 1}) {
+		t.Fatalf("Distance = %x, want ff01...", distance)
+	}
+
+	if a.bucketIndex(b) != 0 || a.bucketIndex(ID{0x0E}) != 7 || a.bucketIndex(a) != -1 {
+		t.Fatalf("wrong bucket indexes: %d, %d, %d", a.bucketIndex(b), a.bucketIndex(ID{0x0E}), a.bucketIndex(a))
+	}
+
+	if !a.Closer(ID{0x0E}, b) || a.Closer(b, ID{0x0E}) {
+		t.Fatalf("0e should be closer than %x to %x", b, a)
+	}
+}
+
+func TestRoutingTableKeepsOldestContacts(t *testing.T) {
+	rt := NewRoutingTable(ID{})
+
+	// Every ID starting with bit 1 goes to the first bucket
+	for i := 0; i < BucketSize+2; i++ {
+		rt.Update(Contact{ID: ID{0x80, byte(i)}, Addr: strconv.Itoa(i)})
+	}
+	rt.Update(Contact{ID: ID{}, Addr: "self"})
+
+	if rt.Len() != BucketSize {
+		t.Fatalf("Len() = %d, want %d", rt.Len(), BucketSize)
+	}
+
+	if !rt.ContainsAddr("0") || rt.ContainsAddr(strconv.Itoa(BucketSize)) || rt.ContainsAddr("self") {
+		t.Fatalf("bucket should keep the oldest contacts")
+	}
+
+	if rt.Update(Contact{ID: ID{0x80, 1}, Addr: "1"}) {
+		t.Fatalf("known contact should not be added again")
+	}
+
+	rt.Remove(ID{0x80, 0})
+	if !rt.Update(Contact{ID: ID{0x80, BucketSize}, Addr: "new"}) || rt.ContainsAddr("0") || !rt.ContainsAddr("new") {
+		t.Fatalf("removed contact should make room for a new one")
+	}
+}
+
+func TestRoutingTableClosest(t *testing.T) {
+	rt := NewRoutingTable(ID{})
+	for i := 1; i <= 50; i++ {
+		rt.Update(Contact{ID: ID{byte(i)}, Addr: strconv.Itoa(i)})
+	}
+
+	closest := rt.Closest(ID{0x10}, 3)
+	expected := []byte{0x10, 0x11, 0x12}
+	if len(closest) != len(expected) {
+		t.Fatalf("Closest returned %d contacts, want %d", len(closest), len(expected))
+	}
+
+	for i, contact := range closest {
+		if contact.ID[0] != expected[i] {
+			t.Fatalf("contact %d = %x, want %x", i, contact.ID[0], expected[i])
+		}
+	}
+}
+
+func TestPeerStoreExpires(t *testing.T) {
+	ps := NewPeerStore(50 * time.Millisecond)
+	ps.Add(ID{1}, "b")
+	ps.Add(ID{1}, "a")
+	ps.Add(ID{1}, "a")
+
+	peers := ps.Get(ID{1})
+	if len(peers) != 2 || peers[0] != "a" || peers[1] != "b" {
+		t.Fatalf("Get = %v, want [a b]", peers)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if len(ps.Get(ID{1})) != 0 {
+		t.Fatalf("peers should have expired")
+	}
+
+	ps.Expire()
+	if len(ps.peers) != 0 {
+		t.Fatalf("expired peers should be removed")
+	}
+}
+
+// Simulates a network where every node has seen every other one, but only keeps k contacts per bucket,
+// and checks the lookup finds the closest node to the target
+func TestLookupConverges(t *testing.T) {
+	const nodes = 200
+
+	tables := make([]*RoutingTable, nodes)
+	ids := make([]ID, nodes)
+	for i := range ids {
+		ids[i], _ = NewRandomID()
+		tables[i] = NewRoutingTable(ids[i])
+	}
+
+	index := make(map[ID]int)
+	for i := range tables {
+		index[ids[i]] = i
+		for j := range ids {
+			tables[i].Update(Contact{ID: ids[j]})
+		}
+	}
+
+	target, _ := NewRandomID()
+	lookup := NewLookup(target, tables[0].Closest(target, BucketSize))
+
+	// The first contacts queried fail
+	for _, contact := range lookup.Next(1) {
+		lookup.Failed(contact.ID)
+	}
+
+	for next := lookup.Next(Alpha); len(next) != 0; next = lookup.Next(Alpha) {
+		for _, contact := range next {
+			lookup.Answered(contact.ID, tables[index[contact.ID]].Closest(target, BucketSize))
+		}
+	}
+
+	closest := lookup.Closest()
+	if len(closest) != BucketSize {
+		t.Fatalf("lookup found %d contacts, want %d", len(closest), BucketSize)
+	}
+
+	// The closest node (other than a failed one) must have been found
+	all := make([]Contact, 0, nodes)
+	for _, id := range ids {
+		all = append(all, Contact{ID: id})
+	}
+	sortByDistance(target, all)
+
+	found := false
+	for _, contact := range all[:2] {
+		if contact.ID == closest[0].ID {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("lookup did not find the closest node")
+	}
+}
@@ -2,20 +2,18 @@ package filewriter
 
 import (
 	"PessiTorrent/internal/logger"
+	"PessiTorrent/internal/multifile"
+	"PessiTorrent/internal/protocol"
 	"PessiTorrent/internal/utils"
-	"os"
 	"sync"
 )
 
 const (
-	Permissions = 0666
-	Flags       = os.O_WRONLY | os.O_CREATE
-
 	WorkerPoolSize = 10
 )
 
 type FileWriter struct {
-	file        *os.File
+	file        *multifile.File
 	fileName    string
 	chunkSize   uint64
 	chunksQueue chan Chunk
@@ -29,17 +27,9 @@ type Chunk struct {
 	data  []uint8
 }
 
-func NewFileWriter(fileName string, fileSize uint64, onWrite func(index uint16), filePath string) (*FileWriter, error) {
-	// Create sparse file
-	file, err := os.OpenFile(filePath, Flags, Permissions)
-	if err != nil {
-		return nil, err
-	}
-	_, err = file.Seek(int64(fileSize-1), 0)
-	if err != nil {
-		return nil, err
-	}
-	_, err = file.Write([]uint8{0})
+func NewFileWriter(fileName string, fileSize uint64, onWrite func(index uint16), filePath string, files []protocol.ManifestEntry) (*FileWriter, error) {
+	// Create sparse files (or reuse the existing ones, keeping the chunks already written to them)
+	file, err := multifile.Create(filePath, fileSize, files)
 	if err != nil {
 		return nil, err
 	}
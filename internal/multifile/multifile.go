@@ -0,0 +1,249 @@
+package multifile
+
+import (
+	"PessiTorrent/internal/protocol"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	Permissions          = 0666
+	DirectoryPermissions = 0755
+)
+
+// File lays out the files of a manifest one after the other in a single byte space,
+// so that a directory can be split into chunks (and downloaded) as if it was a single file.
+// A file without a manifest is treated as a manifest with a single entry for the file itself.
+type File struct {
+	files   []*os.File
+	entries []protocol.ManifestEntry
+	size    uint64
+}
+
+// Open opens the files of the manifest, relative to the root path, for reading
+func Open(root string, entries []protocol.ManifestEntry) (*File, error) {
+	if len(entries) == 0 {
+		stats, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = []protocol.ManifestEntry{protocol.NewManifestEntry("", uint64(stats.Size()), 0)}
+	}
+
+	return open(root, entries, os.O_RDONLY)
+}
+
+// Create creates (or reuses) the files of the manifest, relative to the root path, for reading and writing.
+// Every file is truncated to its size in the manifest, keeping the data already written to it.
+func Create(root string, size uint64, entries []protocol.ManifestEntry) (*File, error) {
+	if len(entries) == 0 {
+		entries = []protocol.ManifestEntry{protocol.NewManifestEntry("", size, 0)}
+	}
+
+	err := ValidateManifest(entries, size)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := open(root, entries, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, file := range f.files {
+		err = file.Truncate(int64(entries[i].Size))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func open(root string, entries []protocol.ManifestEntry, flags int) (*File, error) {
+	f := &File{
+		files:   make([]*os.File, 0, len(entries)),
+		entries: entries,
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(root, filepath.FromSlash(entry.Path))
+
+		if flags&os.O_CREATE != 0 {
+			err := os.MkdirAll(filepath.Dir(path), DirectoryPermissions)
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+
+		file, err := os.OpenFile(path, flags, Permissions)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		f.files = append(f.files, file)
+		f.size += entry.Size
+	}
+
+	return f, nil
+}
+
+// ValidateManifest checks that every path of the manifest stays inside the root directory, that
+// no two entries refer to the same file and that the files are laid out contiguously, without
+// overlapping, in the given size
+func ValidateManifest(entries []protocol.ManifestEntry, size uint64) error {
+	paths := make(map[string]bool, len(entries))
+
+	var offset uint64
+	for _, entry := range entries {
+		path := filepath.FromSlash(entry.Path)
+		if entry.Path != "" && !filepath.IsLocal(path) {
+			return fmt.Errorf("invalid path %s in manifest", entry.Path)
+		}
+
+		path = filepath.Clean(path)
+		if paths[path] {
+			return fmt.Errorf("duplicated path %s in manifest", entry.Path)
+		}
+		paths[path] = true
+
+		if entry.Offset != offset {
+			return fmt.Errorf("file %s is not contiguous with the previous one", entry.Path)
+		}
+		offset += entry.Size
+	}
+
+	if offset != size {
+		return fmt.Errorf("manifest size %d does not match file size %d", offset, size)
+	}
+
+	return nil
+}
+
+// Size returns the total size of the files
+func (f *File) Size() uint64 {
+	return f.size
+}
+
+// Returns the index of the entry which contains the byte at the given offset
+func (f *File) entryAt(offset uint64) int {
+	return sort.Search(len(f.entries), func(i int) bool {
+		return f.entries[i].Offset+f.entries[i].Size > offset
+	})
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	read := 0
+	for i := f.entryAt(uint64(off)); i < len(f.entries) && read < len(p); i++ {
+		entry := f.entries[i]
+		position := uint64(off) + uint64(read) - entry.Offset
+
+		end := len(p)
+		if remaining := entry.Size - position; uint64(end-read) > remaining {
+			end = read + int(remaining)
+		}
+
+		n, err := f.files[i].ReadAt(p[read:end], int64(position))
+		read += n
+		if err != nil && !(errors.Is(err, io.EOF) && read == end) {
+			return read, err
+		}
+	}
+
+	if read < len(p) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	written := 0
+	for i := f.entryAt(uint64(off)); i < len(f.entries) && written < len(p); i++ {
+		entry := f.entries[i]
+		position := uint64(off) + uint64(written) - entry.Offset
+
+		end := len(p)
+		if remaining := entry.Size - position; uint64(end-written) > remaining {
+			end = written + int(remaining)
+		}
+
+		n, err := f.files[i].WriteAt(p[written:end], int64(position))
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if written < len(p) {
+		return written, errors.New("write beyond the end of the files")
+	}
+
+	return written, nil
+}
+
+func (f *File) Close() error {
+	var err error
+	for _, file := range f.files {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// BuildManifest walks a directory and returns the manifest of every regular file in it (in lexical order),
+// along with their total size
+func BuildManifest(directory string) ([]protocol.ManifestEntry, uint64, error) {
+	entries := make([]protocol.ManifestEntry, 0)
+	var offset uint64
+
+	err := filepath.WalkDir(directory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		relative, err := filepath.Rel(directory, path)
+		if err != nil {
+			return err
+		}
+
+		size := uint64(info.Size())
+		entries = append(entries, protocol.NewManifestEntry(filepath.ToSlash(relative), size, offset))
+		offset += size
+
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, offset, nil
+}
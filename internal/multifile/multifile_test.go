@@ -0,0 +1,125 @@
+package multifile
+
+import (
+	"PessiTorrent/internal/protocol"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAndReadAcrossFiles(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "dir")
+	entries := []protocol.ManifestEntry{
+		protocol.NewManifestEntry("a.txt", 3, 0),
+		protocol.NewManifestEntry("empty.txt", 0, 3),
+		protocol.NewManifestEntry("sub/b.txt", 5, 3),
+	}
+
+	file, err := Create(root, 8, entries)
+	if err != nil {
+		t.Fatalf("error creating files: %v", err)
+	}
+
+	// Write spanning the boundary between the files
+	_, err = file.WriteAt([]byte("cdefg"), 2)
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	_, err = file.WriteAt([]byte("ab"), 0)
+	if err != nil {
+		t.Fatalf("error writing: %v", err)
+	}
+	file.Close()
+
+	b, err := os.ReadFile(filepath.Join(root, "sub", "b.txt"))
+	if err != nil || !bytes.Equal(b, []byte("defg\x00")) {
+		t.Fatalf("sub/b.txt = %q (%v), want \"defg\\x00\"", b, err)
+	}
+
+	file, err = Open(root, entries)
+	if err != nil {
+		t.Fatalf("error opening files: %v", err)
+	}
+	defer file.Close()
+
+	content := make([]byte, 10)
+	read, err := file.ReadAt(content, 0)
+	if read != 8 || err != io.EOF {
+		t.Fatalf("ReadAt = %d, %v, want 8, EOF", read, err)
+	}
+
+	if !bytes.Equal(content[:read], []byte("abcdefg\x00")) {
+		t.Fatalf("content = %q, want \"abcdefg\\x00\"", content[:read])
+	}
+}
+
+func TestOpenWithoutManifest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	os.WriteFile(path, []byte("content"), Permissions)
+
+	file, err := Open(path, nil)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	defer file.Close()
+
+	if file.Size() != 7 {
+		t.Fatalf("Size() = %d, want 7", file.Size())
+	}
+
+	content := make([]byte, 4)
+	_, err = file.ReadAt(content, 3)
+	if err != nil || string(content) != "tent" {
+		t.Fatalf("ReadAt = %q (%v), want \"tent\"", content, err)
+	}
+}
+
+func TestBuildManifest(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "sub"), DirectoryPermissions)
+	os.WriteFile(filepath.Join(root, "b.txt"), []byte("bb"), Permissions)
+	os.WriteFile(filepath.Join(root, "sub", "a.txt"), []byte("aaa"), Permissions)
+
+	entries, size, err := BuildManifest(root)
+	if err != nil {
+		t.Fatalf("error building manifest: %v", err)
+	}
+
+	expected := []protocol.ManifestEntry{
+		protocol.NewManifestEntry("b.txt", 2, 0),
+		protocol.NewManifestEntry("sub/a.txt", 3, 2),
+	}
+
+	if size != 5 || len(entries) != len(expected) {
+		t.Fatalf("BuildManifest = %v, %d, want %v, 5", entries, size, expected)
+	}
+
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Fatalf("entry %d = %v, want %v", i, entries[i], expected[i])
+		}
+	}
+}
+
+func TestValidateManifest(t *testing.T) {
+	invalid := [][]protocol.ManifestEntry{
+		{protocol.NewManifestEntry("../escape.txt", 1, 0)},
+		{protocol.NewManifestEntry("/absolute.txt", 1, 0)},
+		{protocol.NewManifestEntry("a.txt", 1, 0), protocol.NewManifestEntry("b.txt", 1, 0)},
+		{protocol.NewManifestEntry("a.txt", 2, 0)},
+		{protocol.NewManifestEntry("a.txt", 1, 0), protocol.NewManifestEntry("a.txt", 0, 1)},
+		{protocol.NewManifestEntry("a/b.txt", 1, 0), protocol.NewManifestEntry("a/./b.txt", 0, 1)},
+	}
+
+	for _, entries := range invalid {
+		if ValidateManifest(entries, 1) == nil {
+			t.Fatalf("manifest %v should be invalid", entries)
+		}
+	}
+
+	if err := ValidateManifest([]protocol.ManifestEntry{protocol.NewManifestEntry("a/b.txt", 1, 0)}, 1); err != nil {
+		t.Fatalf("manifest should be valid: %v", err)
+	}
+}
@@ -36,6 +36,18 @@ func NewCheckedBitfield(size int) Bitfield {
 	return EncodeBitField(bools)
 }
 
+// Returns the number of bits set to 1 among the first size bits of the bitfield
+func CountSetBits(bitfield Bitfield, size int) int {
+	count := 0
+	for i := 0; i < size && i/8 < len(bitfield); i++ {
+		if GetBit(bitfield, i) {
+			count++
+		}
+	}
+
+	return count
+}
+
 // Sets the bit value at a given position in the bitfield to 1 (starting at 0)
 func SetBit(bitfield []uint8, position int) {
 	offset := position / 8
@@ -0,0 +1,20 @@
+package protocol
+
+import "testing"
+
+func TestCountSetBits(t *testing.T) {
+	bitfield := EncodeBitField([]bool{true, false, true, true, false, false, false, false, true, true})
+
+	if count := CountSetBits(bitfield, 10); count != 5 {
+		t.Fatalf("CountSetBits(bitfield, 10) = %d, want 5", count)
+	}
+
+	if count := CountSetBits(bitfield, 3); count != 2 {
+		t.Fatalf("CountSetBits(bitfield, 3) = %d, want 2", count)
+	}
+
+	// Sizes beyond the bitfield only count the bits it has
+	if count := CountSetBits(NewCheckedBitfield(4), 100); count != 4 {
+		t.Fatalf("CountSetBits(checked bitfield, 100) = %d, want 4", count)
+	}
+}
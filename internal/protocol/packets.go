@@ -21,20 +21,31 @@ func (ip *InitPacket) GetPacketType() uint8 {
 	return InitType
 }
 
-// PublishFilePacket is sent by the node to the tracker when it wants to publish a file
+// PublishFilePacket is sent by the node to the tracker when it wants to publish a file.
+// Directories are published as a single file, with a manifest of the files in it (empty for regular files).
+// In merkle hash mode, ChunkHashes is empty and the chunks are verified against MerkleRoot instead.
+// FileHash is the first 20 bytes of the digest of the file with HashAlgorithm, which is also used for the chunks.
 type PublishFilePacket struct {
-	FileName    string
-	FileSize    uint64
-	FileHash    [20]byte
-	ChunkHashes [][20]byte
+	FileName      string
+	FileSize      uint64
+	FileHash      [20]byte
+	HashAlgorithm uint8
+	HashMode      uint8
+	ChunkHashes   [][]uint8
+	MerkleRoot    []uint8
+	Files         []ManifestEntry
 }
 
-func NewPublishFilePacket(fileName string, fileSize uint64, fileHash [20]byte, chunkHashes [][20]byte) PublishFilePacket {
+func NewPublishFilePacket(fileName string, fileSize uint64, fileHash [20]byte, hashAlgorithm uint8, hashMode uint8, chunkHashes [][]uint8, merkleRoot []uint8, files []ManifestEntry) PublishFilePacket {
 	return PublishFilePacket{
-		FileName:    fileName,
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		FileHash:      fileHash,
+		HashAlgorithm: hashAlgorithm,
+		HashMode:      hashMode,
+		ChunkHashes:   chunkHashes,
+		MerkleRoot:    merkleRoot,
+		Files:         files,
 	}
 }
 
@@ -42,15 +53,46 @@ func (pf *PublishFilePacket) GetPacketType() uint8 {
 	return PublishFileType
 }
 
+// PublishFilesPacket is sent by the node to the tracker when it wants to publish many files at once.
+// The tracker answers each of them as if it was sent in its own PublishFilePacket.
+type PublishFilesPacket struct {
+	Files []PublishFilePacket
+}
+
+func NewPublishFilesPacket(files []PublishFilePacket) PublishFilesPacket {
+	return PublishFilesPacket{
+		Files: files,
+	}
+}
+
+func (pf *PublishFilesPacket) GetPacketType() uint8 {
+	return PublishFilesType
+}
+
+// ManifestEntry describes one of the files of a directory, laid out after the previous ones in the chunks of the directory
+type ManifestEntry struct {
+	Path   string // Path relative to the directory, with forward slashes
+	Size   uint64
+	Offset uint64 // Offset of the file in the directory
+}
+
+func NewManifestEntry(path string, size uint64, offset uint64) ManifestEntry {
+	return ManifestEntry{
+		Path:   path,
+		Size:   size,
+		Offset: offset,
+	}
+}
+
 // UpdateChunksPacket is sent by the node to the tracker when it wants to update the tracker about the chunks it has from a file
 type UpdateChunksPacket struct {
-	FileName string
+	FileHash [20]byte
 	Bitfield Bitfield
 }
 
-func NewUpdateChunksPacket(fileName string, bitfield Bitfield) UpdateChunksPacket {
+func NewUpdateChunksPacket(fileHash [20]byte, bitfield Bitfield) UpdateChunksPacket {
 	return UpdateChunksPacket{
-		FileName: fileName,
+		FileHash: fileHash,
 		Bitfield: bitfield,
 	}
 }
@@ -59,14 +101,17 @@ func (pc *UpdateChunksPacket) GetPacketType() uint8 {
 	return UpdateChunksType
 }
 
-// RequestFilePacket is sent by the node to the tracker when it wants to download a file to get information about the file
+// RequestFilePacket is sent by the node to the tracker when it wants to download a file to get information about the file.
+// The file is looked up by its hash or, when the hash is zero, by its name.
 type RequestFilePacket struct {
 	FileName string
+	FileHash [20]byte
 }
 
-func NewRequestFilePacket(fileName string) RequestFilePacket {
+func NewRequestFilePacket(fileName string, fileHash [20]byte) RequestFilePacket {
 	return RequestFilePacket{
 		FileName: fileName,
+		FileHash: fileHash,
 	}
 }
 
@@ -74,13 +119,28 @@ func (rf *RequestFilePacket) GetPacketType() uint8 {
 	return RequestFileType
 }
 
+// ChallengeResponsePacket is sent by the node to the tracker to prove it knows its pre-shared key
+type ChallengeResponsePacket struct {
+	MAC [32]byte
+}
+
+func NewChallengeResponsePacket(mac [32]byte) ChallengeResponsePacket {
+	return ChallengeResponsePacket{
+		MAC: mac,
+	}
+}
+
+func (cr *ChallengeResponsePacket) GetPacketType() uint8 {
+	return ChallengeResponseType
+}
+
 type UpdateFilePacket struct {
-	FileName string
+	FileHash [20]byte
 }
 
-func NewUpdateFilePacket(fileName string) UpdateFilePacket {
+func NewUpdateFilePacket(fileHash [20]byte) UpdateFilePacket {
 	return UpdateFilePacket{
-		FileName: fileName,
+		FileHash: fileHash,
 	}
 }
 
@@ -88,25 +148,142 @@ func (uf *UpdateFilePacket) GetPacketType() uint8 {
 	return UpdateFileType
 }
 
+// SearchPacket is sent by the node to the tracker to look for files whose name matches a pattern
+// (a glob pattern if it contains any of '*', '?' or '[', otherwise a substring)
+type SearchPacket struct {
+	Pattern string
+}
+
+func NewSearchPacket(pattern string) SearchPacket {
+	return SearchPacket{
+		Pattern: pattern,
+	}
+}
+
+func (s *SearchPacket) GetPacketType() uint8 {
+	return SearchType
+}
+
+// ListFilesPacket is sent by the node to the tracker to get every file it tracks
+type ListFilesPacket struct{}
+
+func NewListFilesPacket() ListFilesPacket {
+	return ListFilesPacket{}
+}
+
+func (lf *ListFilesPacket) GetPacketType() uint8 {
+	return ListFilesType
+}
+
+// RequestPeersPacket is sent by the node to the tracker to get the nodes which have chunks of a file.
+// The file is looked up by its hash or, when the hash is zero, by its name.
+type RequestPeersPacket struct {
+	FileName string
+	FileHash [20]byte
+}
+
+func NewRequestPeersPacket(fileName string, fileHash [20]byte) RequestPeersPacket {
+	return RequestPeersPacket{
+		FileName: fileName,
+		FileHash: fileHash,
+	}
+}
+
+func (rp *RequestPeersPacket) GetPacketType() uint8 {
+	return RequestPeersType
+}
+
+// HeartbeatPacket is sent periodically by the node to the tracker to show it is still alive
+type HeartbeatPacket struct{}
+
+func NewHeartbeatPacket() HeartbeatPacket {
+	return HeartbeatPacket{}
+}
+
+func (h *HeartbeatPacket) GetPacketType() uint8 {
+	return HeartbeatType
+}
+
+// SubscribeFilePacket is sent by the node to the tracker when it starts downloading a file,
+// to be sent the chunks other nodes get of it as a SwarmDeltaPacket
+type SubscribeFilePacket struct {
+	FileHash [20]byte
+}
+
+func NewSubscribeFilePacket(fileHash [20]byte) SubscribeFilePacket {
+	return SubscribeFilePacket{
+		FileHash: fileHash,
+	}
+}
+
+func (sf *SubscribeFilePacket) GetPacketType() uint8 {
+	return SubscribeFileType
+}
+
+// UnsubscribeFilePacket is sent by the node to the tracker when it no longer needs the chunks other nodes get of a file
+type UnsubscribeFilePacket struct {
+	FileHash [20]byte
+}
+
+func NewUnsubscribeFilePacket(fileHash [20]byte) UnsubscribeFilePacket {
+	return UnsubscribeFilePacket{
+		FileHash: fileHash,
+	}
+}
+
+func (uf *UnsubscribeFilePacket) GetPacketType() uint8 {
+	return UnsubscribeFileType
+}
+
 // TRACKER -> NODE
 
+// ChallengePacket is sent by the tracker to the node after receiving its InitPacket,
+// when the tracker requires nodes to authenticate
+type ChallengePacket struct {
+	Nonce [32]byte
+}
+
+func NewChallengePacket(nonce [32]byte) ChallengePacket {
+	return ChallengePacket{
+		Nonce: nonce,
+	}
+}
+
+func (c *ChallengePacket) GetPacketType() uint8 {
+	return ChallengeType
+}
+
+// AuthenticatedPacket is sent by the tracker to the node when it is allowed to publish and remove files
+type AuthenticatedPacket struct{}
+
+func NewAuthenticatedPacket() AuthenticatedPacket {
+	return AuthenticatedPacket{}
+}
+
+func (a *AuthenticatedPacket) GetPacketType() uint8 {
+	return AuthenticatedType
+}
+
 // FileSuccessPacket is sent by the tracker to the node when it
 // has successfully published(Type = PublishFileType)/removed(Type = RemoveFileType) a file
 type FileSuccessPacket struct {
 	FileName string
+	FileHash [20]byte
 	Type     uint8
 }
 
-func NewPublishFileSuccessPacket(fileName string) FileSuccessPacket {
+func NewPublishFileSuccessPacket(fileName string, fileHash [20]byte) FileSuccessPacket {
 	return FileSuccessPacket{
 		FileName: fileName,
+		FileHash: fileHash,
 		Type:     PublishFileType,
 	}
 }
 
-func NewRemoveFileSuccessPacket(fileName string) FileSuccessPacket {
+func NewRemoveFileSuccessPacket(fileName string, fileHash [20]byte) FileSuccessPacket {
 	return FileSuccessPacket{
 		FileName: fileName,
+		FileHash: fileHash,
 		Type:     RemoveFileType,
 	}
 }
@@ -115,14 +292,16 @@ func (fs *FileSuccessPacket) GetPacketType() uint8 {
 	return FileSuccessType
 }
 
-// AlreadyExistsPacket is sent by the tracker to the node when it wants to publish a file that already exists in the network
+// AlreadyExistsPacket is sent by the tracker to the node when it wants to publish a file it is already seeding
 type AlreadyExistsPacket struct {
 	Filename string
+	FileHash [20]byte
 }
 
-func NewAlreadyExistsPacket(filename string) AlreadyExistsPacket {
+func NewAlreadyExistsPacket(filename string, fileHash [20]byte) AlreadyExistsPacket {
 	return AlreadyExistsPacket{
 		Filename: filename,
+		FileHash: fileHash,
 	}
 }
 
@@ -130,14 +309,34 @@ func (ae *AlreadyExistsPacket) GetPacketType() uint8 {
 	return AlreadyExistsType
 }
 
+// ConflictPacket is sent by the tracker to the node when it wants to publish a file with the same hash
+// as an existing one, but with a different size or chunks
+type ConflictPacket struct {
+	FileName string
+	FileHash [20]byte
+}
+
+func NewConflictPacket(fileName string, fileHash [20]byte) ConflictPacket {
+	return ConflictPacket{
+		FileName: fileName,
+		FileHash: fileHash,
+	}
+}
+
+func (c *ConflictPacket) GetPacketType() uint8 {
+	return ConflictType
+}
+
 // NotFoundPacket is sent by the tracker to the node when it wants to download or remove a file that does not exist
 type NotFoundPacket struct {
 	Filename string
+	FileHash [20]byte
 }
 
-func NewNotFoundPacket(filename string) NotFoundPacket {
+func NewNotFoundPacket(filename string, fileHash [20]byte) NotFoundPacket {
 	return NotFoundPacket{
 		Filename: filename,
+		FileHash: fileHash,
 	}
 }
 
@@ -145,13 +344,52 @@ func (nf *NotFoundPacket) GetPacketType() uint8 {
 	return NotFoundType
 }
 
+// PermissionDeniedPacket is sent by the tracker to the node when it wants to remove a file it did not publish
+type PermissionDeniedPacket struct {
+	Filename string
+	FileHash [20]byte
+}
+
+func NewPermissionDeniedPacket(filename string, fileHash [20]byte) PermissionDeniedPacket {
+	return PermissionDeniedPacket{
+		Filename: filename,
+		FileHash: fileHash,
+	}
+}
+
+func (pd *PermissionDeniedPacket) GetPacketType() uint8 {
+	return PermissionDeniedType
+}
+
+// AmbiguousFilePacket is sent by the tracker to the node when it requests a file by a name
+// shared by more than one file, with the hashes of every file with that name
+type AmbiguousFilePacket struct {
+	FileName   string
+	FileHashes [][20]byte
+}
+
+func NewAmbiguousFilePacket(fileName string, fileHashes [][20]byte) AmbiguousFilePacket {
+	return AmbiguousFilePacket{
+		FileName:   fileName,
+		FileHashes: fileHashes,
+	}
+}
+
+func (af *AmbiguousFilePacket) GetPacketType() uint8 {
+	return AmbiguousFileType
+}
+
 // AnswerFileWithNodesPacket is sent by the tracker to the node when it wants to download a file to give information about the file
 type AnswerFileWithNodesPacket struct {
-	FileName    string
-	FileSize    uint64
-	FileHash    [20]byte
-	ChunkHashes [][20]byte
-	Nodes       []NodeFileInfo
+	FileName      string
+	FileSize      uint64
+	FileHash      [20]byte
+	HashAlgorithm uint8
+	HashMode      uint8
+	ChunkHashes   [][]uint8
+	MerkleRoot    []uint8
+	Files         []ManifestEntry
+	Nodes         []NodeFileInfo
 }
 
 type NodeFileInfo struct {
@@ -160,12 +398,16 @@ type NodeFileInfo struct {
 	Bitfield []uint8
 }
 
-func NewAnswerFileWithNodesPacket(fileName string, fileSize uint64, fileHash [20]byte, chunkHashes [][20]byte, names []string, ports []uint16, bitfields []Bitfield) AnswerFileWithNodesPacket {
+func NewAnswerFileWithNodesPacket(fileName string, fileSize uint64, fileHash [20]byte, hashAlgorithm uint8, hashMode uint8, chunkHashes [][]uint8, merkleRoot []uint8, files []ManifestEntry, names []string, ports []uint16, bitfields []Bitfield) AnswerFileWithNodesPacket {
 	an := AnswerFileWithNodesPacket{
-		FileName:    fileName,
-		FileSize:    fileSize,
-		FileHash:    fileHash,
-		ChunkHashes: chunkHashes,
+		FileName:      fileName,
+		FileSize:      fileSize,
+		FileHash:      fileHash,
+		HashAlgorithm: hashAlgorithm,
+		HashMode:      hashMode,
+		ChunkHashes:   chunkHashes,
+		MerkleRoot:    merkleRoot,
+		Files:         files,
 	}
 
 	for i := 0; i < len(bitfields); i++ {
@@ -187,13 +429,13 @@ func (an *AnswerFileWithNodesPacket) GetPacketType() uint8 {
 }
 
 type AnswerNodesPacket struct {
-	FileName string
+	FileHash [20]byte
 	Nodes    []NodeFileInfo
 }
 
-func NewAnswerNodesPacket(fileName string, names []string, ports []uint16, bitfields []Bitfield) AnswerNodesPacket {
+func NewAnswerNodesPacket(fileHash [20]byte, names []string, ports []uint16, bitfields []Bitfield) AnswerNodesPacket {
 	an := AnswerNodesPacket{
-		FileName: fileName,
+		FileHash: fileHash,
 	}
 
 	for i := 0; i < len(bitfields); i++ {
@@ -215,12 +457,12 @@ func (an *AnswerNodesPacket) GetPacketType() uint8 {
 }
 
 type RemoveFilePacket struct {
-	FileName string
+	FileHash [20]byte
 }
 
-func NewRemoveFilePacket(fileName string) RemoveFilePacket {
+func NewRemoveFilePacket(fileHash [20]byte) RemoveFilePacket {
 	return RemoveFilePacket{
-		FileName: fileName,
+		FileHash: fileHash,
 	}
 }
 
@@ -228,16 +470,128 @@ func (rf *RemoveFilePacket) GetPacketType() uint8 {
 	return RemoveFileType
 }
 
+// SearchResultsPacket is sent by the tracker to the node with the files matching its search
+type SearchResultsPacket struct {
+	Pattern string
+	Results []SearchResult
+}
+
+type SearchResult struct {
+	FileName string
+	FileHash [20]byte
+	FileSize uint64
+	Seeders  uint32 // Nodes with every chunk of the file
+	Leechers uint32 // Nodes with only some chunks of the file
+}
+
+func NewSearchResultsPacket(pattern string, results []SearchResult) SearchResultsPacket {
+	return SearchResultsPacket{
+		Pattern: pattern,
+		Results: results,
+	}
+}
+
+func (sr *SearchResultsPacket) GetPacketType() uint8 {
+	return SearchResultsType
+}
+
+// FileListPacket is sent by the tracker to the node with every file it tracks
+type FileListPacket struct {
+	Files []FileListEntry
+}
+
+type FileListEntry struct {
+	FileName       string
+	FileHash       [20]byte
+	FileSize       uint64
+	NumberOfChunks uint32
+	ChunkCopies    uint64 // Sum of the chunks of the file held by each connected node
+}
+
+func NewFileListPacket(files []FileListEntry) FileListPacket {
+	return FileListPacket{
+		Files: files,
+	}
+}
+
+func (fl *FileListPacket) GetPacketType() uint8 {
+	return FileListType
+}
+
+// PeersPacket is sent by the tracker to the node with the nodes which have chunks of a file
+type PeersPacket struct {
+	FileName       string
+	FileHash       [20]byte
+	NumberOfChunks uint32
+	Nodes          []NodeFileInfo
+}
+
+func NewPeersPacket(fileName string, fileHash [20]byte, numberOfChunks uint32, names []string, ports []uint16, bitfields []Bitfield) PeersPacket {
+	p := PeersPacket{
+		FileName:       fileName,
+		FileHash:       fileHash,
+		NumberOfChunks: numberOfChunks,
+	}
+
+	for i := 0; i < len(bitfields); i++ {
+		node := NodeFileInfo{
+			Name:     names[i],
+			Port:     ports[i],
+			Bitfield: bitfields[i],
+		}
+		p.Nodes = append(p.Nodes, node)
+	}
+
+	return p
+}
+
+func (p *PeersPacket) GetPacketType() uint8 {
+	return PeersType
+}
+
+// HeartbeatAckPacket is sent by the tracker to the node in response to a HeartbeatPacket
+type HeartbeatAckPacket struct{}
+
+func NewHeartbeatAckPacket() HeartbeatAckPacket {
+	return HeartbeatAckPacket{}
+}
+
+func (ha *HeartbeatAckPacket) GetPacketType() uint8 {
+	return HeartbeatAckType
+}
+
+// SwarmDeltaPacket is sent by the tracker to the nodes subscribed to a file when another node gets chunks of it,
+// with only the chunks the node did not have before
+type SwarmDeltaPacket struct {
+	FileHash [20]byte
+	Name     string
+	Port     uint16
+	Chunks   []uint16
+}
+
+func NewSwarmDeltaPacket(fileHash [20]byte, name string, port uint16, chunks []uint16) SwarmDeltaPacket {
+	return SwarmDeltaPacket{
+		FileHash: fileHash,
+		Name:     name,
+		Port:     port,
+		Chunks:   chunks,
+	}
+}
+
+func (sd *SwarmDeltaPacket) GetPacketType() uint8 {
+	return SwarmDeltaType
+}
+
 // NODE -> NODE
 
 type RequestChunksPacket struct {
-	FileName string
+	FileHash [20]byte
 	Chunks   []uint16
 }
 
-func NewRequestChunksPacket(fileName string, chunks []uint16) RequestChunksPacket {
+func NewRequestChunksPacket(fileHash [20]byte, chunks []uint16) RequestChunksPacket {
 	return RequestChunksPacket{
-		FileName: fileName,
+		FileHash: fileHash,
 		Chunks:   chunks,
 	}
 }
@@ -247,19 +601,335 @@ func (rc *RequestChunksPacket) GetPacketType() uint8 {
 }
 
 type ChunkPacket struct {
-	FileName     string
+	FileHash     [20]byte
 	Chunk        uint16
 	ChunkContent []uint8
+	Proof        [][]uint8 // Merkle proof of the chunk, empty in flat hash mode
 }
 
-func NewChunkPacket(fileName string, chunk uint16, chunkContent []uint8) ChunkPacket {
+func NewChunkPacket(fileHash [20]byte, chunk uint16, chunkContent []uint8, proof [][]uint8) ChunkPacket {
 	return ChunkPacket{
-		FileName:     fileName,
+		FileHash:     fileHash,
 		Chunk:        chunk,
 		ChunkContent: chunkContent,
+		Proof:        proof,
 	}
 }
 
 func (c *ChunkPacket) GetPacketType() uint8 {
 	return ChunkType
 }
+
+// FragmentPacket carries a piece of a packet which is too large to be sent in a single datagram
+type FragmentPacket struct {
+	MessageID uint32
+	Index     uint16
+	Total     uint16
+	Payload   []uint8
+}
+
+func NewFragmentPacket(messageID uint32, index uint16, total uint16, payload []uint8) FragmentPacket {
+	return FragmentPacket{
+		MessageID: messageID,
+		Index:     index,
+		Total:     total,
+		Payload:   payload,
+	}
+}
+
+func (f *FragmentPacket) GetPacketType() uint8 {
+	return FragmentType
+}
+
+// FragmentNackPacket is sent by the receiver of a fragmented packet to ask for the fragments it is missing
+type FragmentNackPacket struct {
+	MessageID uint32
+	Missing   []uint16
+}
+
+func NewFragmentNackPacket(messageID uint32, missing []uint16) FragmentNackPacket {
+	return FragmentNackPacket{
+		MessageID: messageID,
+		Missing:   missing,
+	}
+}
+
+func (fn *FragmentNackPacket) GetPacketType() uint8 {
+	return FragmentNackType
+}
+
+// DHTFindNodePacket is sent by a node to another one in the DHT to get the contacts it knows closest to Target.
+// Every DHT packet carries the ID of its sender, so the receiver can add it to its routing table.
+type DHTFindNodePacket struct {
+	SenderID  [20]byte
+	RequestID uint32 // Echoed in the answer, to match it with the request
+	Target    [20]byte
+}
+
+func NewDHTFindNodePacket(senderID [20]byte, requestID uint32, target [20]byte) DHTFindNodePacket {
+	return DHTFindNodePacket{
+		SenderID:  senderID,
+		RequestID: requestID,
+		Target:    target,
+	}
+}
+
+func (fn *DHTFindNodePacket) GetPacketType() uint8 {
+	return DHTFindNodeType
+}
+
+// DHTContact is a node of the DHT, with the UDP address it was seen at
+type DHTContact struct {
+	ID   [20]byte
+	Addr string
+}
+
+// DHTNodesPacket is sent by a node in response to a DHTFindNodePacket
+type DHTNodesPacket struct {
+	SenderID  [20]byte
+	RequestID uint32
+	Nodes     []DHTContact
+}
+
+func NewDHTNodesPacket(senderID [20]byte, requestID uint32, nodes []DHTContact) DHTNodesPacket {
+	return DHTNodesPacket{
+		SenderID:  senderID,
+		RequestID: requestID,
+		Nodes:     nodes,
+	}
+}
+
+func (n *DHTNodesPacket) GetPacketType() uint8 {
+	return DHTNodesType
+}
+
+// DHTFindPeersPacket is sent by a node to another one in the DHT to get the peers which announced to have
+// chunks of a file
+type DHTFindPeersPacket struct {
+	SenderID  [20]byte
+	RequestID uint32
+	FileHash  [20]byte
+}
+
+func NewDHTFindPeersPacket(senderID [20]byte, requestID uint32, fileHash [20]byte) DHTFindPeersPacket {
+	return DHTFindPeersPacket{
+		SenderID:  senderID,
+		RequestID: requestID,
+		FileHash:  fileHash,
+	}
+}
+
+func (fp *DHTFindPeersPacket) GetPacketType() uint8 {
+	return DHTFindPeersType
+}
+
+// DHTPeersPacket is sent by a node in response to a DHTFindPeersPacket, with the UDP addresses of the peers it
+// knows and the contacts it knows closest to the file, to continue the lookup with
+type DHTPeersPacket struct {
+	SenderID  [20]byte
+	RequestID uint32
+	Peers     []string
+	Nodes     []DHTContact
+}
+
+func NewDHTPeersPacket(senderID [20]byte, requestID uint32, peers []string, nodes []DHTContact) DHTPeersPacket {
+	return DHTPeersPacket{
+		SenderID:  senderID,
+		RequestID: requestID,
+		Peers:     peers,
+		Nodes:     nodes,
+	}
+}
+
+func (p *DHTPeersPacket) GetPacketType() uint8 {
+	return DHTPeersType
+}
+
+// DHTAnnouncePacket is sent by a node to the nodes of the DHT closest to a file it has chunks of.
+// The peer is reachable at the address the packet was sent from.
+type DHTAnnouncePacket struct {
+	SenderID [20]byte
+	FileHash [20]byte
+}
+
+func NewDHTAnnouncePacket(senderID [20]byte, fileHash [20]byte) DHTAnnouncePacket {
+	return DHTAnnouncePacket{
+		SenderID: senderID,
+		FileHash: fileHash,
+	}
+}
+
+func (a *DHTAnnouncePacket) GetPacketType() uint8 {
+	return DHTAnnounceType
+}
+
+// RequestFileInfoPacket is sent by a node to a peer found in the DHT, to get the information about a file
+// the tracker would otherwise send
+type RequestFileInfoPacket struct {
+	FileHash [20]byte
+}
+
+func NewRequestFileInfoPacket(fileHash [20]byte) RequestFileInfoPacket {
+	return RequestFileInfoPacket{
+		FileHash: fileHash,
+	}
+}
+
+func (rf *RequestFileInfoPacket) GetPacketType() uint8 {
+	return RequestFileInfoType
+}
+
+// FileInfoPacket is sent by a node in response to a RequestFileInfoPacket, with the chunks it has of the file
+type FileInfoPacket struct {
+	FileName      string
+	FileSize      uint64
+	FileHash      [20]byte
+	HashAlgorithm uint8
+	HashMode      uint8
+	ChunkHashes   [][]uint8
+	MerkleRoot    []uint8
+	Files         []ManifestEntry
+	Bitfield      Bitfield
+}
+
+func NewFileInfoPacket(fileName string, fileSize uint64, fileHash [20]byte, hashAlgorithm uint8, hashMode uint8, chunkHashes [][]uint8, merkleRoot []uint8, files []ManifestEntry, bitfield Bitfield) FileInfoPacket {
+	return FileInfoPacket{
+		FileName:      fileName,
+		FileSize:      fileSize,
+		FileHash:      fileHash,
+		HashAlgorithm: hashAlgorithm,
+		HashMode:      hashMode,
+		ChunkHashes:   chunkHashes,
+		MerkleRoot:    merkleRoot,
+		Files:         files,
+		Bitfield:      bitfield,
+	}
+}
+
+func (fi *FileInfoPacket) GetPacketType() uint8 {
+	return FileInfoType
+}
+
+// HavePacket is sent by a node downloading a file to the other nodes it knows with chunks of it,
+// as soon as it gets a new chunk
+type HavePacket struct {
+	FileHash [20]byte
+	Chunk    uint16
+}
+
+func NewHavePacket(fileHash [20]byte, chunk uint16) HavePacket {
+	return HavePacket{
+		FileHash: fileHash,
+		Chunk:    chunk,
+	}
+}
+
+func (h *HavePacket) GetPacketType() uint8 {
+	return HaveType
+}
+
+// ChokePacket is sent by a node to another one it will not send chunks to, until it sends an UnchokePacket.
+// It is also the answer to the chunks requested while choked.
+type ChokePacket struct{}
+
+func NewChokePacket() ChokePacket {
+	return ChokePacket{}
+}
+
+func (c *ChokePacket) GetPacketType() uint8 {
+	return ChokeType
+}
+
+// UnchokePacket is sent by a node to another one it choked, when it will send it chunks again
+type UnchokePacket struct{}
+
+func NewUnchokePacket() UnchokePacket {
+	return UnchokePacket{}
+}
+
+func (u *UnchokePacket) GetPacketType() uint8 {
+	return UnchokeType
+}
+
+// PeerExchangePacket is sent periodically by a node downloading a file to the other nodes it knows with chunks of it,
+// with the chunks it has and the nodes it knows, so the nodes of a file find each other without the tracker
+type PeerExchangePacket struct {
+	FileHash [20]byte
+	Bitfield Bitfield // Chunks of the file the sender has
+	Peers    []ExchangedPeer
+}
+
+// ExchangedPeer is a node with chunks of a file, reachable at a UDP address
+type ExchangedPeer struct {
+	Addr     string
+	Bitfield Bitfield
+}
+
+func NewPeerExchangePacket(fileHash [20]byte, bitfield Bitfield, peers []ExchangedPeer) PeerExchangePacket {
+	return PeerExchangePacket{
+		FileHash: fileHash,
+		Bitfield: bitfield,
+		Peers:    peers,
+	}
+}
+
+func (pe *PeerExchangePacket) GetPacketType() uint8 {
+	return PeerExchangeType
+}
+
+// TRACKER -> TRACKER
+
+// FederationSubscribePacket is sent by a tracker to another one it federates with, to receive its state
+type FederationSubscribePacket struct{}
+
+func NewFederationSubscribePacket() FederationSubscribePacket {
+	return FederationSubscribePacket{}
+}
+
+func (fs *FederationSubscribePacket) GetPacketType() uint8 {
+	return FederationSubscribeType
+}
+
+// FederationStatePacket is sent periodically by a tracker to the trackers subscribed to it.
+// It carries the files published in the tracker and the bitfields of the nodes connected to it,
+// replacing the state previously sent by the same tracker.
+type FederationStatePacket struct {
+	Files []FederatedFile
+	Nodes []FederatedNode
+}
+
+// FederatedFile is a file published in the tracker which sends it
+type FederatedFile struct {
+	FileName      string
+	FileSize      uint64
+	FileHash      [20]byte
+	HashAlgorithm uint8
+	HashMode      uint8
+	ChunkHashes   [][]uint8
+	MerkleRoot    []uint8
+	Files         []ManifestEntry
+	Owner         string // Name of the node which published the file
+}
+
+// FederatedNode is a node connected to the tracker which sends it, with the bitfield of every file it has chunks of
+type FederatedNode struct {
+	Name  string
+	Port  uint16
+	Files []FederatedBitfield
+}
+
+type FederatedBitfield struct {
+	FileHash [20]byte
+	Bitfield Bitfield
+}
+
+func NewFederationStatePacket(files []FederatedFile, nodes []FederatedNode) FederationStatePacket {
+	return FederationStatePacket{
+		Files: files,
+		Nodes: nodes,
+	}
+}
+
+func (fs *FederationStatePacket) GetPacketType() uint8 {
+	return FederationStateType
+}
@@ -62,12 +62,19 @@ func TestBytes(t *testing.T) {
 
 func TestSerialize(t *testing.T) {
 	// create dummy PublishFilePacket
-	packet := NewPublishFilePacket("test.txt", 6, [20]byte{1, 2, 3, 4, 5}, [][20]byte{{6, 7, 8}, {9, 10, 11}})
+	packet := NewPublishFilePacket("test", 6, [20]byte{1, 2, 3, 4, 5}, HashAlgorithmSHA256, HashModeFlat, [][]uint8{{6, 7, 8}, {9, 10, 11}}, []uint8{}, []ManifestEntry{NewManifestEntry("a.txt", 2, 0), NewManifestEntry("dir/b.txt", 4, 2)})
 
 	var deserialize PublishFilePacket
 	testSerializeStruct(&packet, &deserialize, t)
 	checkEquals(packet, deserialize, t)
 
+	// create dummy PublishFilesPacket
+	publishFilesPacket := NewPublishFilesPacket([]PublishFilePacket{packet, NewPublishFilePacket("other", 0, [20]byte{6}, HashAlgorithmSHA1, HashModeMerkle, [][]uint8{}, []uint8{1, 2}, []ManifestEntry{})})
+
+	var deserializePublishFiles PublishFilesPacket
+	testSerializeStruct(&publishFilesPacket, &deserializePublishFiles, t)
+	checkEquals(publishFilesPacket, deserializePublishFiles, t)
+
 	// create dummy InitPacket
 	initPacket := NewInitPacket("portatil1.local", 1234)
 
@@ -76,18 +83,42 @@ func TestSerialize(t *testing.T) {
 	checkEquals(initPacket, deserializeInit, t)
 
 	// create dummy UpdateChunksPacket
-	publishChunkPacket := NewUpdateChunksPacket("test.txt", EncodeBitField([]bool{true, true, true, true, true}))
+	publishChunkPacket := NewUpdateChunksPacket([20]byte{1, 2, 3}, EncodeBitField([]bool{true, true, true, true, true}))
 
 	var deserializePublishChunk UpdateChunksPacket
 	testSerializeStruct(&publishChunkPacket, &deserializePublishChunk, t)
 	checkEquals(publishChunkPacket, deserializePublishChunk, t)
 
 	// create dummy AnswerNodesPacket
-	answerNodesPacket := NewAnswerFileWithNodesPacket("filename.txt", 5, [20]byte{1, 2, 3, 4, 5}, [][20]byte{{6, 7, 8}, {9, 10, 11}}, []string{"portatil1.local"}, []uint16{1, 2, 3, 4, 5}, []Bitfield{EncodeBitField([]bool{true, true, true, true, true})})
+	answerNodesPacket := NewAnswerFileWithNodesPacket("filename.txt", 5, [20]byte{1, 2, 3, 4, 5}, HashAlgorithmSHA1, HashModeMerkle, [][]uint8{}, []uint8{12, 13}, []ManifestEntry{NewManifestEntry("a.txt", 5, 0)}, []string{"portatil1.local"}, []uint16{1, 2, 3, 4, 5}, []Bitfield{EncodeBitField([]bool{true, true, true, true, true})})
 
 	var deserializeAnswerNodes AnswerFileWithNodesPacket
 	testSerializeStruct(&answerNodesPacket, &deserializeAnswerNodes, t)
 	checkEquals(answerNodesPacket, deserializeAnswerNodes, t)
+
+	// create dummy FederationStatePacket
+	federationStatePacket := NewFederationStatePacket(
+		[]FederatedFile{{FileName: "a.txt", FileSize: 5, FileHash: [20]byte{1}, ChunkHashes: [][]uint8{{1, 2}}, MerkleRoot: []uint8{}, Files: []ManifestEntry{}, Owner: "portatil1.local"}},
+		[]FederatedNode{{Name: "portatil2.local", Port: 8081, Files: []FederatedBitfield{{FileHash: [20]byte{1}, Bitfield: EncodeBitField([]bool{true})}}}},
+	)
+
+	var deserializeFederationState FederationStatePacket
+	testSerializeStruct(&federationStatePacket, &deserializeFederationState, t)
+	checkEquals(federationStatePacket, deserializeFederationState, t)
+
+	// create dummy DHTPeersPacket
+	dhtPeersPacket := NewDHTPeersPacket([20]byte{1}, 7, []string{"10.0.0.1:8081"}, []DHTContact{{ID: [20]byte{2}, Addr: "10.0.0.2:8081"}})
+
+	var deserializeDHTPeers DHTPeersPacket
+	testSerializeStruct(&dhtPeersPacket, &deserializeDHTPeers, t)
+	checkEquals(dhtPeersPacket, deserializeDHTPeers, t)
+
+	// create dummy PeerExchangePacket
+	peerExchangePacket := NewPeerExchangePacket([20]byte{1}, EncodeBitField([]bool{true, false}), []ExchangedPeer{{Addr: "10.0.0.1:8081", Bitfield: EncodeBitField([]bool{false, true})}})
+
+	var deserializePeerExchange PeerExchangePacket
+	testSerializeStruct(&peerExchangePacket, &deserializePeerExchange, t)
+	checkEquals(peerExchangePacket, deserializePeerExchange, t)
 }
 
 func checkEquals(a interface{}, b interface{}, t *testing.T) {
@@ -14,6 +14,52 @@ const (
 	RemoveFileType          = 10
 	RequestChunksType       = 11
 	ChunkType               = 12
+	FragmentType            = 13
+	FragmentNackType        = 14
+	ChallengeType           = 15
+	ChallengeResponseType   = 16
+	AuthenticatedType       = 17
+	PermissionDeniedType    = 18
+	ConflictType            = 19
+	AmbiguousFileType       = 20
+	SearchType              = 21
+	SearchResultsType       = 22
+	ListFilesType           = 23
+	FileListType            = 24
+	RequestPeersType        = 25
+	PeersType               = 26
+	PublishFilesType        = 27
+	HeartbeatType           = 28
+	HeartbeatAckType        = 29
+	FederationSubscribeType = 30
+	FederationStateType     = 31
+	DHTFindNodeType         = 32
+	DHTNodesType            = 33
+	DHTFindPeersType        = 34
+	DHTPeersType            = 35
+	DHTAnnounceType         = 36
+	RequestFileInfoType     = 37
+	FileInfoType            = 38
+	PeerExchangeType        = 39
+	SubscribeFileType       = 40
+	UnsubscribeFileType     = 41
+	SwarmDeltaType          = 42
+	HaveType                = 43
+	ChokeType               = 44
+	UnchokeType             = 45
+)
+
+// How the chunks of a file are verified
+const (
+	HashModeFlat   = 0 // The hash of every chunk is sent with the file
+	HashModeMerkle = 1 // Only the root of the merkle tree of the chunks is sent with the file, and a proof with every chunk
+)
+
+// Hash algorithms used to identify files and verify their chunks
+const (
+	HashAlgorithmSHA1    = 0
+	HashAlgorithmSHA256  = 1
+	HashAlgorithmBLAKE2b = 2
 )
 
 type Packet interface {
@@ -48,6 +94,72 @@ func PacketStructFromType(packetType uint8) Packet {
 		return &RequestChunksPacket{}
 	case ChunkType:
 		return &ChunkPacket{}
+	case FragmentType:
+		return &FragmentPacket{}
+	case FragmentNackType:
+		return &FragmentNackPacket{}
+	case ChallengeType:
+		return &ChallengePacket{}
+	case ChallengeResponseType:
+		return &ChallengeResponsePacket{}
+	case AuthenticatedType:
+		return &AuthenticatedPacket{}
+	case PermissionDeniedType:
+		return &PermissionDeniedPacket{}
+	case ConflictType:
+		return &ConflictPacket{}
+	case AmbiguousFileType:
+		return &AmbiguousFilePacket{}
+	case SearchType:
+		return &SearchPacket{}
+	case SearchResultsType:
+		return &SearchResultsPacket{}
+	case ListFilesType:
+		return &ListFilesPacket{}
+	case FileListType:
+		return &FileListPacket{}
+	case RequestPeersType:
+		return &RequestPeersPacket{}
+	case PeersType:
+		return &PeersPacket{}
+	case PublishFilesType:
+		return &PublishFilesPacket{}
+	case HeartbeatType:
+		return &HeartbeatPacket{}
+	case HeartbeatAckType:
+		return &HeartbeatAckPacket{}
+	case FederationSubscribeType:
+		return &FederationSubscribePacket{}
+	case FederationStateType:
+		return &FederationStatePacket{}
+	case DHTFindNodeType:
+		return &DHTFindNodePacket{}
+	case DHTNodesType:
+		return &DHTNodesPacket{}
+	case DHTFindPeersType:
+		return &DHTFindPeersPacket{}
+	case DHTPeersType:
+		return &DHTPeersPacket{}
+	case DHTAnnounceType:
+		return &DHTAnnouncePacket{}
+	case RequestFileInfoType:
+		return &RequestFileInfoPacket{}
+	case FileInfoType:
+		return &FileInfoPacket{}
+	case PeerExchangeType:
+		return &PeerExchangePacket{}
+	case SubscribeFileType:
+		return &SubscribeFilePacket{}
+	case UnsubscribeFileType:
+		return &UnsubscribeFilePacket{}
+	case SwarmDeltaType:
+		return &SwarmDeltaPacket{}
+	case HaveType:
+		return &HavePacket{}
+	case ChokeType:
+		return &ChokePacket{}
+	case UnchokeType:
+		return &UnchokePacket{}
 	default:
 		return nil
 	}
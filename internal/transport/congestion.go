@@ -0,0 +1,185 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	InitialWindow       = 4
+	MinWindow           = 1
+	MaxWindow           = 256
+	InitialSlowStartEnd = 64
+
+	InitialRTO = 500 * time.Millisecond
+	MinRTO     = 100 * time.Millisecond
+	MaxRTO     = 5 * time.Second
+)
+
+// CongestionController limits the number of requests in flight to a peer, identified by keys of type K.
+// The window grows additively (after an initial slow start) while requests are answered
+// and is halved when requests time out, with the timeout derived from the observed RTT (RFC 6298).
+type CongestionController[K comparable] struct {
+	sync.Mutex
+
+	window        float64
+	slowStartEnd  float64
+	inFlight      map[K]time.Time // Request key -> Time it was sent
+	lastDecrease  time.Time
+	smoothedRTT   time.Duration
+	rttVariation  time.Duration
+	retransmitRTO time.Duration
+}
+
+func NewCongestionController[K comparable]() *CongestionController[K] {
+	return &CongestionController[K]{
+		window:        InitialWindow,
+		slowStartEnd:  InitialSlowStartEnd,
+		inFlight:      make(map[K]time.Time),
+		retransmitRTO: InitialRTO,
+	}
+}
+
+// Available returns how many more requests can be sent right now
+func (cc *CongestionController[K]) Available() int {
+	cc.Lock()
+	defer cc.Unlock()
+
+	available := int(cc.window) - len(cc.inFlight)
+	if available < 0 {
+		return 0
+	}
+
+	return available
+}
+
+// Sent registers a request as in flight
+func (cc *CongestionController[K]) Sent(key K) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	cc.inFlight[key] = time.Now()
+}
+
+// Acked registers the answer to a request in flight, returning its round trip time
+func (cc *CongestionController[K]) Acked(key K) (time.Duration, bool) {
+	cc.Lock()
+	defer cc.Unlock()
+
+	sentAt, ok := cc.inFlight[key]
+	if !ok {
+		return 0, false
+	}
+	delete(cc.inFlight, key)
+
+	rtt := time.Since(sentAt)
+	cc.updateRTO(rtt)
+
+	if cc.window < cc.slowStartEnd {
+		cc.window++
+	} else {
+		cc.window += 1 / cc.window
+	}
+
+	if cc.window > MaxWindow {
+		cc.window = MaxWindow
+	}
+
+	return rtt, true
+}
+
+// Expire drops the requests in flight for longer than the retransmission timeout, which are
+// considered lost, and returns their keys. The window is decreased at most once per RTO.
+func (cc *CongestionController[K]) Expire() []K {
+	cc.Lock()
+	defer cc.Unlock()
+
+	lost := make([]K, 0)
+	for key, sentAt := range cc.inFlight {
+		if time.Since(sentAt) > cc.retransmitRTO {
+			lost = append(lost, key)
+			delete(cc.inFlight, key)
+		}
+	}
+
+	if len(lost) != 0 && time.Since(cc.lastDecrease) > cc.retransmitRTO {
+		cc.lastDecrease = time.Now()
+		cc.slowStartEnd = cc.window / 2
+		cc.window /= 2
+		if cc.window < MinWindow {
+			cc.window = MinWindow
+		}
+
+		// Back off the timeout until a new RTT sample arrives
+		cc.retransmitRTO *= 2
+		if cc.retransmitRTO > MaxRTO {
+			cc.retransmitRTO = MaxRTO
+		}
+	}
+
+	return lost
+}
+
+// Cancel drops every request in flight without considering them lost, such as when the peer
+// will not answer them on purpose, and returns their keys
+func (cc *CongestionController[K]) Cancel() []K {
+	cc.Lock()
+	defer cc.Unlock()
+
+	cancelled := make([]K, 0, len(cc.inFlight))
+	for key := range cc.inFlight {
+		cancelled = append(cancelled, key)
+	}
+	cc.inFlight = make(map[K]time.Time)
+
+	return cancelled
+}
+
+func (cc *CongestionController[K]) Window() int {
+	cc.Lock()
+	defer cc.Unlock()
+
+	return int(cc.window)
+}
+
+func (cc *CongestionController[K]) InFlight() int {
+	cc.Lock()
+	defer cc.Unlock()
+
+	return len(cc.inFlight)
+}
+
+func (cc *CongestionController[K]) RTT() time.Duration {
+	cc.Lock()
+	defer cc.Unlock()
+
+	return cc.smoothedRTT
+}
+
+func (cc *CongestionController[K]) RTO() time.Duration {
+	cc.Lock()
+	defer cc.Unlock()
+
+	return cc.retransmitRTO
+}
+
+func (cc *CongestionController[K]) updateRTO(rtt time.Duration) {
+	if cc.smoothedRTT == 0 {
+		cc.smoothedRTT = rtt
+		cc.rttVariation = rtt / 2
+	} else {
+		diff := cc.smoothedRTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		cc.rttVariation = (3*cc.rttVariation + diff) / 4
+		cc.smoothedRTT = (7*cc.smoothedRTT + rtt) / 8
+	}
+
+	cc.retransmitRTO = cc.smoothedRTT + 4*cc.rttVariation
+	if cc.retransmitRTO < MinRTO {
+		cc.retransmitRTO = MinRTO
+	} else if cc.retransmitRTO > MaxRTO {
+		cc.retransmitRTO = MaxRTO
+	}
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWindowGrowsOnAcks(t *testing.T) {
+	cc := NewCongestionController[string]()
+
+	if cc.Available() != InitialWindow {
+		t.Fatalf("Expected %d available requests, got %d", InitialWindow, cc.Available())
+	}
+
+	for i := 0; i < InitialWindow; i++ {
+		cc.Sent(strconv.Itoa(i))
+	}
+
+	if cc.Available() != 0 {
+		t.Fatalf("Expected no available requests with a full window, got %d", cc.Available())
+	}
+
+	for i := 0; i < InitialWindow; i++ {
+		_, ok := cc.Acked(strconv.Itoa(i))
+		if !ok {
+			t.Fatalf("Request %d was not in flight", i)
+		}
+	}
+
+	if cc.Window() != 2*InitialWindow {
+		t.Errorf("Expected window to double during slow start, got %d", cc.Window())
+	}
+
+	if _, ok := cc.Acked("unknown"); ok {
+		t.Errorf("Unknown request acknowledged")
+	}
+}
+
+func TestWindowHalvesOnLoss(t *testing.T) {
+	cc := NewCongestionController[string]()
+	cc.window = 32
+
+	for i := 0; i < 4; i++ {
+		cc.Sent(strconv.Itoa(i))
+		cc.inFlight[strconv.Itoa(i)] = time.Now().Add(-2 * InitialRTO)
+	}
+
+	lost := cc.Expire()
+	if len(lost) != 4 {
+		t.Fatalf("Expected 4 lost requests, got %d", len(lost))
+	}
+
+	if cc.Window() != 16 {
+		t.Errorf("Expected window to be halved once, got %d", cc.Window())
+	}
+
+	if cc.InFlight() != 0 {
+		t.Errorf("Expected lost requests to leave the window, got %d in flight", cc.InFlight())
+	}
+
+	if cc.RTO() != 2*InitialRTO {
+		t.Errorf("Expected RTO to back off to %s, got %s", 2*InitialRTO, cc.RTO())
+	}
+}
+
+func TestCancelKeepsWindow(t *testing.T) {
+	cc := NewCongestionController[string]()
+	cc.window = 32
+
+	for i := 0; i < 4; i++ {
+		cc.Sent(strconv.Itoa(i))
+	}
+
+	cancelled := cc.Cancel()
+	if len(cancelled) != 4 {
+		t.Fatalf("Expected 4 cancelled requests, got %d", len(cancelled))
+	}
+
+	if cc.InFlight() != 0 || len(cc.Expire()) != 0 {
+		t.Errorf("Expected cancelled requests to leave the window")
+	}
+
+	if cc.Window() != 32 || cc.RTO() != InitialRTO {
+		t.Errorf("Expected window and RTO to be kept, got %d and %s", cc.Window(), cc.RTO())
+	}
+}
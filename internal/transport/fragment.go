@@ -0,0 +1,232 @@
+package transport
+
+import (
+	"PessiTorrent/internal/protocol"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum payload of a single fragment, so that every datagram fits in a typical MTU
+	FragmentPayloadSize = 1400
+	// Maximum size of a message split into fragments, and so the maximum number of fragments of a message
+	MaxMessageSize = 8 << 20
+	MaxFragments   = (MaxMessageSize + FragmentPayloadSize - 1) / FragmentPayloadSize
+	// Maximum number of messages being reassembled at the same time for each address, and for every address
+	MaxPartialMessages      = 32
+	MaxTotalPartialMessages = 1024
+
+	// Time without receiving new fragments of a message before asking for the missing ones
+	ReassemblyTimeout = 200 * time.Millisecond
+	// Number of times the missing fragments are requested before giving up on a message
+	MaxReassemblyRetries = 3
+	// Time a sent message is kept in order to retransmit its fragments
+	SentMessageExpiration = 5 * time.Second
+)
+
+// Splits the serialized packet into fragments of at most FragmentPayloadSize bytes
+func splitIntoFragments(messageID uint32, data []byte) []protocol.FragmentPacket {
+	total := (len(data) + FragmentPayloadSize - 1) / FragmentPayloadSize
+	fragments := make([]protocol.FragmentPacket, 0, total)
+
+	for i := 0; i < total; i++ {
+		end := (i + 1) * FragmentPayloadSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		fragments = append(fragments, protocol.NewFragmentPacket(messageID, uint16(i), uint16(total), data[i*FragmentPayloadSize:end]))
+	}
+
+	return fragments
+}
+
+type messageKey struct {
+	addr      string
+	messageID uint32
+}
+
+type partialMessage struct {
+	fragments  [][]byte
+	received   int
+	size       int // Bytes received so far
+	lastUpdate time.Time
+	retries    int
+}
+
+// Reassembler joins the fragments received from each address back into the original packets
+type Reassembler struct {
+	sync.Mutex
+	messages map[messageKey]*partialMessage
+	partial  map[string]int // Address -> Number of messages being reassembled
+}
+
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		messages: make(map[messageKey]*partialMessage),
+		partial:  make(map[string]int),
+	}
+}
+
+// Add stores the fragment and returns the whole message once all of its fragments have been received.
+// Fragments larger than FragmentPayloadSize, of messages larger than MaxMessageSize, or of new messages
+// when too many are already being reassembled, are dropped.
+func (r *Reassembler) Add(addr string, fragment *protocol.FragmentPacket) ([]byte, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if fragment.Total == 0 || fragment.Total > MaxFragments || fragment.Index >= fragment.Total || len(fragment.Payload) > FragmentPayloadSize {
+		return nil, false
+	}
+
+	key := messageKey{addr, fragment.MessageID}
+	message, ok := r.messages[key]
+	if !ok {
+		if r.partial[addr] >= MaxPartialMessages || len(r.messages) >= MaxTotalPartialMessages {
+			return nil, false
+		}
+
+		message = &partialMessage{fragments: make([][]byte, fragment.Total)}
+		r.messages[key] = message
+		r.partial[addr]++
+	}
+
+	if int(fragment.Total) != len(message.fragments) {
+		return nil, false
+	}
+
+	if message.fragments[fragment.Index] == nil {
+		if message.size+len(fragment.Payload) > MaxMessageSize {
+			r.delete(key)
+			return nil, false
+		}
+
+		message.fragments[fragment.Index] = fragment.Payload
+		message.received++
+		message.size += len(fragment.Payload)
+	}
+	message.lastUpdate = time.Now()
+
+	if message.received < len(message.fragments) {
+		return nil, false
+	}
+
+	r.delete(key)
+
+	data := make([]byte, 0, message.size)
+	for _, payload := range message.fragments {
+		data = append(data, payload...)
+	}
+
+	return data, true
+}
+
+// Missing returns, for each address, the messages which have not received new fragments for
+// longer than ReassemblyTimeout and the fragments they are missing.
+// Messages which have already been retried MaxReassemblyRetries times are discarded.
+func (r *Reassembler) Missing() map[string][]protocol.FragmentNackPacket {
+	r.Lock()
+	defer r.Unlock()
+
+	missing := make(map[string][]protocol.FragmentNackPacket)
+
+	for key, message := range r.messages {
+		if time.Since(message.lastUpdate) < ReassemblyTimeout {
+			continue
+		}
+
+		if message.retries >= MaxReassemblyRetries {
+			r.delete(key)
+			continue
+		}
+
+		indexes := make([]uint16, 0, len(message.fragments)-message.received)
+		for index, payload := range message.fragments {
+			if payload == nil {
+				indexes = append(indexes, uint16(index))
+			}
+		}
+
+		message.retries++
+		message.lastUpdate = time.Now()
+		missing[key.addr] = append(missing[key.addr], protocol.NewFragmentNackPacket(key.messageID, indexes))
+	}
+
+	return missing
+}
+
+func (r *Reassembler) delete(key messageKey) {
+	delete(r.messages, key)
+
+	r.partial[key.addr]--
+	if r.partial[key.addr] == 0 {
+		delete(r.partial, key.addr)
+	}
+}
+
+type sentMessage struct {
+	fragments []protocol.FragmentPacket
+	sentAt    time.Time
+}
+
+// SentMessages keeps the fragments of recently sent messages, so they can be retransmitted on request
+type SentMessages struct {
+	sync.Mutex
+	nextID   uint32
+	messages map[messageKey]*sentMessage
+}
+
+func NewSentMessages() *SentMessages {
+	return &SentMessages{
+		messages: make(map[messageKey]*sentMessage),
+	}
+}
+
+// Split assigns an identifier to the message and splits it into fragments
+func (s *SentMessages) Split(addr string, data []byte) []protocol.FragmentPacket {
+	s.Lock()
+	defer s.Unlock()
+
+	messageID := s.nextID
+	s.nextID++
+
+	fragments := splitIntoFragments(messageID, data)
+	s.messages[messageKey{addr, messageID}] = &sentMessage{
+		fragments: fragments,
+		sentAt:    time.Now(),
+	}
+
+	return fragments
+}
+
+// Get returns the requested fragments of a message previously sent to the given address
+func (s *SentMessages) Get(addr string, nack *protocol.FragmentNackPacket) []protocol.FragmentPacket {
+	s.Lock()
+	defer s.Unlock()
+
+	message, ok := s.messages[messageKey{addr, nack.MessageID}]
+	if !ok {
+		return nil
+	}
+
+	fragments := make([]protocol.FragmentPacket, 0, len(nack.Missing))
+	for _, index := range nack.Missing {
+		if int(index) < len(message.fragments) {
+			fragments = append(fragments, message.fragments[index])
+		}
+	}
+
+	return fragments
+}
+
+// Expire forgets the messages sent more than SentMessageExpiration ago
+func (s *SentMessages) Expire() {
+	s.Lock()
+	defer s.Unlock()
+
+	for key, message := range s.messages {
+		if time.Since(message.sentAt) > SentMessageExpiration {
+			delete(s.messages, key)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"PessiTorrent/internal/protocol"
+	"bytes"
+	"testing"
+)
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	return data
+}
+
+func TestSplitIntoFragments(t *testing.T) {
+	data := testData(3*FragmentPayloadSize + 10)
+
+	fragments := splitIntoFragments(7, data)
+	if len(fragments) != 4 {
+		t.Fatalf("Expected 4 fragments, got %d", len(fragments))
+	}
+
+	for i, fragment := range fragments {
+		if fragment.MessageID != 7 || fragment.Index != uint16(i) || fragment.Total != 4 {
+			t.Errorf("Unexpected fragment header: %d %d %d", fragment.MessageID, fragment.Index, fragment.Total)
+		}
+	}
+
+	if len(fragments[3].Payload) != 10 {
+		t.Errorf("Expected last fragment to have 10 bytes, got %d", len(fragments[3].Payload))
+	}
+}
+
+func TestReassembleOutOfOrder(t *testing.T) {
+	data := testData(5*FragmentPayloadSize + 123)
+	fragments := splitIntoFragments(1, data)
+	reassembler := NewReassembler()
+
+	for i := len(fragments) - 1; i > 0; i-- {
+		_, complete := reassembler.Add("peer", &fragments[i])
+		if complete {
+			t.Fatalf("Message completed before receiving every fragment")
+		}
+	}
+
+	// Duplicated fragments must be ignored
+	_, complete := reassembler.Add("peer", &fragments[2])
+	if complete {
+		t.Fatalf("Message completed with a duplicated fragment")
+	}
+
+	result, complete := reassembler.Add("peer", &fragments[0])
+	if !complete {
+		t.Fatalf("Message not completed after receiving every fragment")
+	}
+
+	if !bytes.Equal(result, data) {
+		t.Errorf("Reassembled data differs from the original")
+	}
+}
+
+func TestMissingFragments(t *testing.T) {
+	data := testData(4 * FragmentPayloadSize)
+	fragments := splitIntoFragments(3, data)
+	reassembler := NewReassembler()
+
+	reassembler.Add("peer", &fragments[0])
+	reassembler.Add("peer", &fragments[2])
+
+	if len(reassembler.Missing()) != 0 {
+		t.Fatalf("Missing fragments requested before the reassembly timeout")
+	}
+
+	reassembler.messages[messageKey{"peer", 3}].lastUpdate = reassembler.messages[messageKey{"peer", 3}].lastUpdate.Add(-ReassemblyTimeout)
+
+	nacks := reassembler.Missing()["peer"]
+	if len(nacks) != 1 || nacks[0].MessageID != 3 {
+		t.Fatalf("Expected a nack for message 3, got %v", nacks)
+	}
+
+	if len(nacks[0].Missing) != 2 || nacks[0].Missing[0] != 1 || nacks[0].Missing[1] != 3 {
+		t.Errorf("Expected fragments 1 and 3 to be missing, got %v", nacks[0].Missing)
+	}
+
+	sent := NewSentMessages()
+	sentFragments := sent.Split("peer", data)
+	nack := protocol.NewFragmentNackPacket(sentFragments[0].MessageID, nacks[0].Missing)
+	resent := sent.Get("peer", &nack)
+	if len(resent) != 2 || !bytes.Equal(resent[1].Payload, sentFragments[3].Payload) {
+		t.Errorf("Unexpected retransmitted fragments")
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	reassembler := NewReassembler()
+
+	oversized := protocol.NewFragmentPacket(0, 0, MaxFragments+1, []byte{1})
+	reassembler.Add("peer", &oversized)
+	if len(reassembler.messages) != 0 {
+		t.Fatalf("Message with more than %d fragments was stored", MaxFragments)
+	}
+
+	large := protocol.NewFragmentPacket(0, 0, 2, make([]byte, FragmentPayloadSize+1))
+	reassembler.Add("peer", &large)
+	if len(reassembler.messages) != 0 {
+		t.Fatalf("Fragment larger than %d bytes was stored", FragmentPayloadSize)
+	}
+
+	for id := uint32(0); id < MaxPartialMessages+10; id++ {
+		fragment := protocol.NewFragmentPacket(id, 0, 2, []byte{1})
+		reassembler.Add("peer", &fragment)
+	}
+	if len(reassembler.messages) != MaxPartialMessages {
+		t.Fatalf("Expected %d partial messages, got %d", MaxPartialMessages, len(reassembler.messages))
+	}
+
+	// Other addresses are not limited by the messages of this one
+	fragment := protocol.NewFragmentPacket(0, 0, 2, []byte{1})
+	reassembler.Add("other", &fragment)
+	if reassembler.partial["other"] != 1 {
+		t.Errorf("Expected a partial message from the other address")
+	}
+
+	// Completing a message frees its slot
+	last := protocol.NewFragmentPacket(0, 1, 2, []byte{2})
+	_, complete := reassembler.Add("peer", &last)
+	if !complete || reassembler.partial["peer"] != MaxPartialMessages-1 {
+		t.Errorf("Expected the completed message to free a slot, got %d partial messages", reassembler.partial["peer"])
+	}
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting the bytes sent or received per second.
+// Tokens are added at the rate, up to a second's worth of them, and each byte takes one.
+// Taking more tokens than available leaves the bucket in debt, so messages larger than the rate are still allowed.
+type RateLimiter struct {
+	sync.Mutex
+	rate   uint64 // Bytes per second, or 0 if there is no limit
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate uint64) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the limit, with 0 meaning no limit
+func (rl *RateLimiter) SetRate(rate uint64) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	rl.rate = rate
+	rl.tokens = min(rl.tokens, float64(rate))
+	rl.last = time.Now()
+}
+
+func (rl *RateLimiter) Rate() uint64 {
+	rl.Lock()
+	defer rl.Unlock()
+
+	return rl.rate
+}
+
+// Adds the tokens for the time since the last refill
+func (rl *RateLimiter) refill() {
+	now := time.Now()
+	rl.tokens = min(rl.tokens+now.Sub(rl.last).Seconds()*float64(rl.rate), float64(rl.rate))
+	rl.last = now
+}
+
+// TryTake takes the tokens for the given number of bytes if any are available, and reports whether it did
+func (rl *RateLimiter) TryTake(bytes uint64) bool {
+	rl.Lock()
+	defer rl.Unlock()
+
+	if rl.rate == 0 {
+		return true
+	}
+
+	rl.refill()
+	if rl.tokens <= 0 {
+		return false
+	}
+
+	rl.tokens -= float64(bytes)
+	return true
+}
+
+// Wait blocks until tokens are available, then takes them for the given number of bytes
+func (rl *RateLimiter) Wait(bytes uint64) {
+	rl.Lock()
+	defer rl.Unlock()
+
+	for rl.rate != 0 {
+		rl.refill()
+		if rl.tokens > 0 {
+			rl.tokens -= float64(bytes)
+			return
+		}
+
+		// Sleeps until the debt is paid, without holding the lock so the rate can be changed
+		wait := time.Duration(-rl.tokens / float64(rl.rate) * float64(time.Second))
+		rl.Unlock()
+		time.Sleep(max(wait, time.Millisecond))
+		rl.Lock()
+	}
+}
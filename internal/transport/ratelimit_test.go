@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	rl := NewRateLimiter(0)
+
+	for i := 0; i < 100; i++ {
+		if !rl.TryTake(1 << 20) {
+			t.Fatalf("Unlimited rate limiter refused to take tokens")
+		}
+	}
+}
+
+func TestRateLimiterDebt(t *testing.T) {
+	rl := NewRateLimiter(1000)
+
+	// Larger than the rate, but tokens are available
+	if !rl.TryTake(1500) {
+		t.Fatalf("Expected tokens to be taken while available")
+	}
+
+	if rl.TryTake(1) {
+		t.Fatalf("Expected no tokens while in debt")
+	}
+
+	// The debt of 500 bytes takes half a second to be paid
+	start := time.Now()
+	rl.Wait(100)
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to wait about 500ms, waited %v", elapsed)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	rl := NewRateLimiter(1000)
+	rl.TryTake(1 << 20)
+
+	rl.SetRate(0)
+	if !rl.TryTake(1) {
+		t.Fatalf("Expected no limit after removing it")
+	}
+
+	if rl.Rate() != 0 {
+		t.Errorf("Expected rate 0, got %d", rl.Rate())
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"strings"
+	"sync"
 )
 
 type TCPPacketHandler func(packet protocol.Packet, conn *TCPConnection)
@@ -18,6 +19,8 @@ type TCPConnection struct {
 	writeQueue   chan protocol.Packet
 	handlePacket TCPPacketHandler
 	onClose      func()
+	stopOnce     *sync.Once
+	quitChannel  chan struct{}
 }
 
 func NewTCPConnection(conn net.Conn, handlePacket TCPPacketHandler, onClose func()) TCPConnection {
@@ -27,6 +30,8 @@ func NewTCPConnection(conn net.Conn, handlePacket TCPPacketHandler, onClose func
 		make(chan protocol.Packet),
 		handlePacket,
 		onClose,
+		&sync.Once{},
+		make(chan struct{}),
 	}
 }
 
@@ -35,21 +40,26 @@ func (conn *TCPConnection) Start() {
 	go conn.readLoop()
 }
 
+// Stop closes the connection. It is safe to call it more than once.
 func (conn *TCPConnection) Stop() {
-	err := conn.connection.Close()
-	if err != nil {
-		logger.Error("Error closing TCP connection:", err)
-	}
+	conn.stopOnce.Do(func() {
+		err := conn.connection.Close()
+		if err != nil {
+			logger.Error("Error closing TCP connection:", err)
+		}
 
-	close(conn.writeQueue)
-	conn.onClose()
+		close(conn.quitChannel)
+		conn.onClose()
+	})
 }
 
 func (conn *TCPConnection) writeLoop() {
 	for {
-		packet := <-conn.writeQueue
-		if packet == nil {
+		var packet protocol.Packet
+		select {
+		case <-conn.quitChannel:
 			return
+		case packet = <-conn.writeQueue:
 		}
 
 		err := protocol.SerializePacket(conn.readWrite, packet)
@@ -66,8 +76,12 @@ func (conn *TCPConnection) writeLoop() {
 	}
 }
 
+// EnqueuePacket queues the packet to be sent. Packets enqueued after the connection is closed are discarded.
 func (conn *TCPConnection) EnqueuePacket(packet protocol.Packet) {
-	conn.writeQueue <- packet
+	select {
+	case <-conn.quitChannel:
+	case conn.writeQueue <- packet:
+	}
 }
 
 func (conn *TCPConnection) readLoop() {
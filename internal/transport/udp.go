@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"errors"
 	"net"
+	"time"
 )
 
 const (
@@ -20,6 +21,10 @@ type UDPServer struct {
 	requestsQueue chan RequestChunk
 	handlePacket  UDPPacketHandler
 	onClose       func()
+
+	reassembler *Reassembler
+	sent        *SentMessages
+	quitChannel chan struct{}
 }
 
 type RequestChunk struct {
@@ -34,16 +39,21 @@ func NewUDPServer(conn net.UDPConn, handlePacket UDPPacketHandler, onClose func(
 		make(chan RequestChunk),
 		handlePacket,
 		onClose,
+		NewReassembler(),
+		NewSentMessages(),
+		make(chan struct{}),
 	}
 }
 
 func (srv *UDPServer) Start() {
 	go srv.writeLoop()
 	go srv.readLoop()
+	go srv.fragmentsLoop()
 }
 
 func (srv *UDPServer) Stop() {
 	srv.connection.Close()
+	close(srv.quitChannel)
 }
 
 func (srv *UDPServer) writeLoop() {
@@ -53,17 +63,7 @@ func (srv *UDPServer) writeLoop() {
 			return
 		}
 
-		buffer := new(bytes.Buffer)
-		err := protocol.SerializePacket(buffer, request.packet)
-		if err != nil {
-			logger.Error("Error serializing packet:", err)
-			continue
-		}
-
-		_, err = srv.connection.WriteToUDP(buffer.Bytes(), request.addr)
-		if err != nil {
-			logger.Error("Error sending packet:", err)
-		}
+		srv.SendPacket(request.packet, request.addr)
 	}
 }
 
@@ -85,10 +85,63 @@ func (srv *UDPServer) readLoop() {
 			continue
 		}
 
-		go srv.handlePacket(packet, addr)
+		switch packet := packet.(type) {
+		case *protocol.FragmentPacket:
+			srv.handleFragment(packet, addr)
+		case *protocol.FragmentNackPacket:
+			srv.handleFragmentNack(packet, addr)
+		default:
+			go srv.handlePacket(packet, addr)
+		}
+	}
+}
+
+// Periodically asks for the missing fragments of incomplete messages and forgets old sent messages
+func (srv *UDPServer) fragmentsLoop() {
+	for {
+		select {
+		case <-srv.quitChannel:
+			return
+		case <-time.After(ReassemblyTimeout / 2):
+			for addrString, nacks := range srv.reassembler.Missing() {
+				addr, err := net.ResolveUDPAddr("udp4", addrString)
+				if err != nil {
+					continue
+				}
+
+				for i := range nacks {
+					srv.write(&nacks[i], addr)
+				}
+			}
+
+			srv.sent.Expire()
+		}
+	}
+}
+
+func (srv *UDPServer) handleFragment(fragment *protocol.FragmentPacket, addr *net.UDPAddr) {
+	data, complete := srv.reassembler.Add(addr.String(), fragment)
+	if !complete {
+		return
+	}
+
+	packet, err := protocol.DeserializePacket(bytes.NewReader(data))
+	if err != nil {
+		logger.Error("Error deserializing reassembled packet:", err)
+		return
+	}
+
+	go srv.handlePacket(packet, addr)
+}
+
+func (srv *UDPServer) handleFragmentNack(nack *protocol.FragmentNackPacket, addr *net.UDPAddr) {
+	fragments := srv.sent.Get(addr.String(), nack)
+	for i := range fragments {
+		srv.write(&fragments[i], addr)
 	}
 }
 
+// SendPacket sends the packet to the given address, splitting it into fragments if it does not fit in a single datagram
 func (srv *UDPServer) SendPacket(packet protocol.Packet, addr *net.UDPAddr) {
 	buffer := new(bytes.Buffer)
 	err := protocol.SerializePacket(buffer, packet)
@@ -97,12 +150,40 @@ func (srv *UDPServer) SendPacket(packet protocol.Packet, addr *net.UDPAddr) {
 		return
 	}
 
-	_, err = srv.connection.WriteToUDP(buffer.Bytes(), addr)
-	if err != nil {
-		logger.Error("Error sending packet:", err)
+	if buffer.Len() > MaxMessageSize {
+		logger.Error("Packet of %d bytes is larger than the maximum message size", buffer.Len())
+		return
+	}
+
+	if buffer.Len() <= FragmentPayloadSize {
+		srv.writeBytes(buffer.Bytes(), addr)
+		return
+	}
+
+	fragments := srv.sent.Split(addr.String(), buffer.Bytes())
+	for i := range fragments {
+		srv.write(&fragments[i], addr)
 	}
 }
 
 func (srv *UDPServer) EnqueueRequest(packet protocol.Packet, addr *net.UDPAddr) {
 	srv.requestsQueue <- RequestChunk{packet, addr}
 }
+
+func (srv *UDPServer) write(packet protocol.Packet, addr *net.UDPAddr) {
+	buffer := new(bytes.Buffer)
+	err := protocol.SerializePacket(buffer, packet)
+	if err != nil {
+		logger.Error("Error serializing packet:", err)
+		return
+	}
+
+	srv.writeBytes(buffer.Bytes(), addr)
+}
+
+func (srv *UDPServer) writeBytes(data []byte, addr *net.UDPAddr) {
+	_, err := srv.connection.WriteToUDP(data, addr)
+	if err != nil {
+		logger.Error("Error sending packet:", err)
+	}
+}
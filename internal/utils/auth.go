@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+// NewNonce returns a random challenge to be signed by a node
+func NewNonce() ([32]byte, error) {
+	var nonce [32]byte
+	_, err := rand.Read(nonce[:])
+	return nonce, err
+}
+
+// ChallengeMAC signs the challenge sent to the node with the given name using its pre-shared key
+func ChallengeMAC(key string, nonce [32]byte, name string) [32]byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(nonce[:])
+	mac.Write([]byte(name))
+
+	var result [32]byte
+	copy(result[:], mac.Sum(nil))
+
+	return result
+}
+
+// ValidChallengeMAC reports whether the MAC sent by a node matches the expected one, in constant time
+func ValidChallengeMAC(key string, nonce [32]byte, name string, received [32]byte) bool {
+	expected := ChallengeMAC(key, nonce, name)
+	return hmac.Equal(expected[:], received[:])
+}
@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"encoding/hex"
+	"fmt"
+	"math"
 	"net"
 	"strconv"
+	"strings"
 )
 
 func TCPAddrToBytes(addr net.Addr) [4]byte {
@@ -47,3 +51,50 @@ func StrToUDPAddr(addr string) ([4]byte, error) {
 
 	return UDPAddrToBytes(ip), nil
 }
+
+// HexToHash parses the hexadecimal representation of a file hash
+func HexToHash(str string) ([20]byte, error) {
+	var hash [20]byte
+
+	decoded, err := hex.DecodeString(str)
+	if err != nil {
+		return hash, err
+	}
+
+	if len(decoded) != len(hash) {
+		return hash, fmt.Errorf("hash must have %d bytes, got %d", len(hash), len(decoded))
+	}
+
+	copy(hash[:], decoded)
+
+	return hash, nil
+}
+
+// ParseRate parses a rate in bytes per second, with an optional unit (e.g. "512", "100KB", "1.5MB/s").
+// Units are multiples of 1024 bytes, and a rate of 0 means no limit.
+func ParseRate(rate string) (uint64, error) {
+	str := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(rate)), "/S")
+
+	multiplier := 1.0
+	for i, unit := range []string{"KB", "MB", "GB"} {
+		if strings.HasSuffix(str, unit) {
+			str = strings.TrimSuffix(str, unit)
+			multiplier = math.Pow(1024, float64(i+1))
+			break
+		}
+	}
+	str = strings.TrimSuffix(str, "B")
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+	if err != nil || value < 0 || math.IsInf(value, 0) || math.IsNaN(value) {
+		return 0, fmt.Errorf("invalid rate %s", rate)
+	}
+
+	// A rate lower than a byte per second would become 0, which means no limit
+	bytes := uint64(value * multiplier)
+	if bytes == 0 && value != 0 {
+		return 0, fmt.Errorf("rate %s is lower than 1 byte per second", rate)
+	}
+
+	return bytes, nil
+}
@@ -42,3 +42,49 @@ func TestStrToUDPPort(t *testing.T) {
 		t.Errorf("StrToUDPPort: expected %v, got %v", expected, result)
 	}
 }
+
+func TestHexToHash(t *testing.T) {
+	result, err := HexToHash("0102030405060708090a0b0c0d0e0f1011121314")
+	if err != nil {
+		t.Errorf("HexToHash: unexpected error: %v", err)
+	}
+
+	expected := [20]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+	if result != expected {
+		t.Errorf("HexToHash: expected %v, got %v", expected, result)
+	}
+
+	_, err = HexToHash("report.pdf")
+	if err == nil {
+		t.Errorf("HexToHash: expected error for invalid hash")
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	rates := map[string]uint64{
+		"0":        0,
+		"0.0":      0,
+		"512":      512,
+		"512B":     512,
+		"100KB":    100 << 10,
+		"1.5mb/s":  3 << 19,
+		" 2 GB/s ": 2 << 30,
+	}
+
+	for str, expected := range rates {
+		result, err := ParseRate(str)
+		if err != nil {
+			t.Errorf("ParseRate(%q): unexpected error: %v", str, err)
+		}
+
+		if result != expected {
+			t.Errorf("ParseRate(%q): expected %d, got %d", str, expected, result)
+		}
+	}
+
+	for _, str := range []string{"", "fast", "-1KB", "1TB", "0.5", "0.0001KB"} {
+		if _, err := ParseRate(str); err == nil {
+			t.Errorf("ParseRate(%q): expected error", str)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"PessiTorrent/internal/protocol"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher is a hash algorithm used to identify files and verify their chunks
+type Hasher interface {
+	Algorithm() uint8 // Identifier of the algorithm in the protocol
+	Name() string
+	Size() int // Size of the digests, in bytes
+	New() hash.Hash
+}
+
+type hasher struct {
+	algorithm uint8
+	name      string
+	size      int
+	new       func() hash.Hash
+}
+
+func (h *hasher) Algorithm() uint8 {
+	return h.algorithm
+}
+
+func (h *hasher) Name() string {
+	return h.name
+}
+
+func (h *hasher) Size() int {
+	return h.size
+}
+
+func (h *hasher) New() hash.Hash {
+	return h.new()
+}
+
+func newBlake2b() hash.Hash {
+	h, _ := blake2b.New256(nil) // Only fails with keys longer than 64 bytes
+	return h
+}
+
+var hashers = map[uint8]Hasher{}
+
+func init() {
+	RegisterHasher(&hasher{protocol.HashAlgorithmSHA1, "sha1", sha1.Size, sha1.New})
+	RegisterHasher(&hasher{protocol.HashAlgorithmSHA256, "sha256", sha256.Size, sha256.New})
+	RegisterHasher(&hasher{protocol.HashAlgorithmBLAKE2b, "blake2b", blake2b.Size256, newBlake2b})
+}
+
+// Makes a hash algorithm available to publish and download files with
+func RegisterHasher(h Hasher) {
+	hashers[h.Algorithm()] = h
+}
+
+func HasherFromAlgorithm(algorithm uint8) (Hasher, error) {
+	h, ok := hashers[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %d", algorithm)
+	}
+
+	return h, nil
+}
+
+func HasherFromName(name string) (Hasher, error) {
+	for _, h := range hashers {
+		if h.Name() == name {
+			return h, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported hash algorithm %s", name)
+}
+
+// Returns the digest of the data
+func Sum(h Hasher, data []byte) []byte {
+	digest := h.New()
+	digest.Write(data)
+
+	return digest.Sum(nil)
+}
@@ -1,66 +1,126 @@
 package utils
 
 import (
-	"crypto/sha1"
+	"bytes"
 	"fmt"
 	"io"
 	"math"
-	"os"
+	"runtime"
+	"sync"
 )
 
-func HashFile(file *os.File) ([20]byte, error) {
-	h := sha1.New()
+// Returns the identifier of the file: the first 20 bytes of the digest of its content
+func HashFile(file io.Reader, hasher Hasher) ([20]byte, error) {
+	h := hasher.New()
 	if _, err := io.Copy(h, file); err != nil {
 		return [20]byte{}, fmt.Errorf("error copying file: %v", err)
 	}
 
 	hash := h.Sum(nil)
 	var hashArr [20]byte
-	copy(hashArr[:], hash[:20])
+	copy(hashArr[:], hash)
 
 	return hashArr, nil
 }
 
-func HashFileChunks(file *os.File, dest *[][20]byte) (uint64, error) {
-	_, err := file.Seek(0, 0)
-	if err != nil {
-		return 0, fmt.Errorf("error seeking file: %v", err)
+// HashFileStream reads the file once, in chunks, computing the identifier of the file and the hash of every chunk.
+// The file is hashed while the chunks are hashed concurrently by a pool of workers, and only a few chunks
+// are kept in memory at a time, so files larger than the memory can be hashed.
+// If not nil, progress is called with the number of bytes hashed so far after every chunk.
+func HashFileStream(file io.Reader, fileSize uint64, hasher Hasher, progress func(hashed uint64)) ([20]byte, [][]byte, error) {
+	if fileSize == 0 {
+		return [20]byte{}, nil, fmt.Errorf("file is empty")
 	}
 
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return 0, fmt.Errorf("error reading file content: %v", err)
+	chunkSize := ChunkSize(fileSize)
+	numberOfChunks := NumberOfChunks(fileSize)
+	workers := runtime.NumCPU()
+
+	type block struct {
+		index int
+		data  []byte
 	}
 
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		return 0, fmt.Errorf("error seeking file: %v", err)
+	// Buffers are reused once both the file and the chunk are hashed, bounding the memory used
+	free := make(chan []byte, 2*workers)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, chunkSize)
 	}
 
-	fileSize := uint64(len(content))
-	if fileSize == 0 {
-		return 0, fmt.Errorf("file is empty")
+	toFileHasher := make(chan block, workers)
+	toChunkHashers := make(chan block, workers)
+	chunkHashes := make([][]byte, numberOfChunks)
+
+	fileDigest := hasher.New()
+	fileHashed := make(chan struct{})
+	go func() {
+		for b := range toFileHasher {
+			fileDigest.Write(b.data)
+			toChunkHashers <- b
+		}
+		close(toChunkHashers)
+		close(fileHashed)
+	}()
+
+	var hashed uint64
+	var progressLock sync.Mutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range toChunkHashers {
+				chunkHashes[b.index] = HashChunk(b.data, hasher)
+
+				progressLock.Lock()
+				hashed += uint64(len(b.data))
+				if progress != nil {
+					progress(hashed)
+				}
+				progressLock.Unlock()
+
+				free <- b.data[:cap(b.data)]
+			}
+		}()
 	}
 
-	chunkSize := ChunkSize(fileSize)
-	numChunks := uint64(math.Ceil(float64(fileSize) / float64(chunkSize)))
+	var read uint64
+	var err error
+	for index := 0; index < int(numberOfChunks); index++ {
+		buffer := <-free
 
-	chunkHashes := make([][20]byte, numChunks)
-	for i := uint64(0); i < numChunks; i++ {
-		if i == numChunks-1 {
-			chunkHashes[i] = sha1.Sum(content[i*chunkSize:])
-		} else {
-			chunkHashes[i] = sha1.Sum(content[i*chunkSize : (i+1)*chunkSize])
+		length := chunkSize
+		if fileSize-read < chunkSize {
+			length = fileSize - read
+		}
+
+		_, err = io.ReadFull(file, buffer[:length])
+		if err != nil {
+			err = fmt.Errorf("error reading file content: %v", err)
+			break
 		}
+		read += length
+
+		toFileHasher <- block{index, buffer[:length]}
 	}
 
-	*dest = chunkHashes
+	close(toFileHasher)
+	<-fileHashed
+	wg.Wait()
 
-	return fileSize, nil
+	if err != nil {
+		return [20]byte{}, nil, err
+	}
+
+	var fileHash [20]byte
+	copy(fileHash[:], fileDigest.Sum(nil))
+
+	return fileHash, chunkHashes, nil
 }
 
-func HashChunk(chunk []byte) [20]byte {
-	return sha1.Sum(chunk)
+func HashChunk(chunk []byte, hasher Hasher) []byte {
+	return Sum(hasher, chunk)
 }
 
 // FileSize -> bytes
@@ -72,3 +132,218 @@ func ChunkSize(fileSize uint64) uint64 {
 	// Calculate the chunk size using the provided equation
 	return uint64(math.Ceil(float64(fileSize)/(float64(chunkCountMultiplier)*float64(chunkBlockSize)))) * chunkBlockSize
 }
+
+// Returns the number of chunks a file with the given size is split into
+func NumberOfChunks(fileSize uint64) uint64 {
+	if fileSize == 0 {
+		return 0
+	}
+
+	chunkSize := ChunkSize(fileSize)
+	return (fileSize + chunkSize - 1) / chunkSize
+}
+
+// MerkleTree is a binary hash tree whose leaves are the hashes of the chunks of a file,
+// so that a single chunk can be verified against the root with the hashes of its siblings (its proof).
+// Nodes are stored in heap order: the root is at index 1 and the children of node i at 2i and 2i+1.
+// The number of leaves is rounded up to a power of two, with zero hashes as padding.
+//
+// A tree built from the root alone only knows the rest of the nodes as chunks are verified,
+// after which it can also provide the proofs of those chunks to other nodes.
+type MerkleTree struct {
+	sync.Mutex
+	hasher Hasher
+	leaves int // Number of chunks
+	width  int // Number of leaves, including padding
+	nodes  [][]byte
+	known  []bool
+}
+
+// Builds the full tree from the hashes of every chunk of a file
+func NewMerkleTree(hasher Hasher, chunkHashes [][]byte) *MerkleTree {
+	t := newMerkleTree(hasher, len(chunkHashes))
+
+	for i := 0; i < t.width; i++ {
+		if i < len(chunkHashes) {
+			t.nodes[t.width+i] = chunkHashes[i]
+		}
+		t.known[t.width+i] = true
+	}
+
+	for i := t.width - 1; i >= 1; i-- {
+		t.nodes[i] = t.hashNodes(t.nodes[2*i], t.nodes[2*i+1])
+		t.known[i] = true
+	}
+
+	return t
+}
+
+// Builds a tree which only knows its root, to verify the chunks of a file as they are downloaded
+func NewPartialMerkleTree(hasher Hasher, root []byte, numberOfChunks int) *MerkleTree {
+	t := newMerkleTree(hasher, numberOfChunks)
+	t.nodes[1] = root
+	t.known[1] = true
+
+	// Padding leaves are known to be zero
+	for i := numberOfChunks; i < t.width; i++ {
+		t.known[t.width+i] = true
+	}
+
+	return t
+}
+
+// Restores a tree from the nodes returned by Nodes
+func RestoreMerkleTree(hasher Hasher, numberOfChunks int, nodes [][]byte, known []bool) (*MerkleTree, error) {
+	t := newMerkleTree(hasher, numberOfChunks)
+	if len(nodes) != len(t.nodes) || len(known) < len(t.known) {
+		return nil, fmt.Errorf("merkle tree of %d chunks must have %d nodes", numberOfChunks, len(t.nodes))
+	}
+
+	for i := range t.nodes {
+		if known[i] {
+			t.nodes[i] = nodes[i]
+			t.known[i] = true
+		}
+	}
+
+	return t, nil
+}
+
+func newMerkleTree(hasher Hasher, numberOfChunks int) *MerkleTree {
+	width := 1
+	for width < numberOfChunks {
+		width *= 2
+	}
+
+	nodes := make([][]byte, 2*width)
+	for i := range nodes {
+		nodes[i] = make([]byte, hasher.Size())
+	}
+
+	return &MerkleTree{
+		hasher: hasher,
+		leaves: numberOfChunks,
+		width:  width,
+		nodes:  nodes,
+		known:  make([]bool, 2*width),
+	}
+}
+
+func (t *MerkleTree) hashNodes(left []byte, right []byte) []byte {
+	h := t.hasher.New()
+	h.Write(left)
+	h.Write(right)
+
+	return h.Sum(nil)
+}
+
+func (t *MerkleTree) Root() []byte {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.nodes[1]
+}
+
+// Returns the hash of a chunk, if it was already verified
+func (t *MerkleTree) Leaf(index int) ([]byte, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if index < 0 || index >= t.leaves {
+		return nil, false
+	}
+
+	return t.nodes[t.width+index], t.known[t.width+index]
+}
+
+// Returns the hashes of the siblings of every node from the chunk up to the root (excluding it),
+// or false if the tree does not know all of them
+func (t *MerkleTree) Proof(index int) ([][]byte, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	if index < 0 || index >= t.leaves {
+		return nil, false
+	}
+
+	proof := make([][]byte, 0)
+	for i := t.width + index; i > 1; i /= 2 {
+		sibling := i ^ 1
+		if !t.known[sibling] {
+			return nil, false
+		}
+		proof = append(proof, t.nodes[sibling])
+	}
+
+	return proof, true
+}
+
+// Verifies the hash of a chunk with its proof, stopping at the first node already known to the tree.
+// If it is valid, every node of the path and the proof become known.
+func (t *MerkleTree) Verify(index int, chunkHash []byte, proof [][]byte) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if index < 0 || index >= t.leaves {
+		return false
+	}
+
+	depth := 0
+	for i := t.width; i > 1; i /= 2 {
+		depth++
+	}
+	if len(proof) != depth {
+		return false
+	}
+
+	// Hash up the tree until reaching a known node
+	path := make(map[int][]byte)
+	hash := chunkHash
+	i := t.width + index
+	for level := 0; !t.known[i]; level++ {
+		if len(proof[level]) != t.hasher.Size() {
+			return false
+		}
+
+		path[i] = hash
+		path[i^1] = proof[level]
+
+		if i%2 == 0 {
+			hash = t.hashNodes(hash, proof[level])
+		} else {
+			hash = t.hashNodes(proof[level], hash)
+		}
+		i /= 2
+	}
+
+	if !bytes.Equal(t.nodes[i], hash) {
+		return false
+	}
+
+	for node, nodeHash := range path {
+		// Known siblings must match the proof as well
+		if t.known[node] && !bytes.Equal(t.nodes[node], nodeHash) {
+			return false
+		}
+	}
+
+	for node, nodeHash := range path {
+		t.nodes[node] = nodeHash
+		t.known[node] = true
+	}
+
+	return true
+}
+
+// Returns every node of the tree and whether it is known, to be stored and later restored
+func (t *MerkleTree) Nodes() ([][]byte, []bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	nodes := make([][]byte, len(t.nodes))
+	known := make([]bool, len(t.known))
+	copy(nodes, t.nodes)
+	copy(known, t.known)
+
+	return nodes, known
+}
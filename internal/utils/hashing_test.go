@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"PessiTorrent/internal/protocol"
+	"bytes"
 	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
 	"io"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -33,7 +38,8 @@ func TestHashFile(t *testing.T) {
 	expectedHash := sha1.Sum([]byte(testContent))
 
 	// Call the HashFile function with the temporary file
-	actualHash, err := HashFile(tempFile)
+	sha1Hasher, _ := HasherFromAlgorithm(protocol.HashAlgorithmSHA1)
+	actualHash, err := HashFile(tempFile, sha1Hasher)
 	if err != nil {
 		t.Fatalf("Error hashing file: %v", err)
 	}
@@ -44,7 +50,7 @@ func TestHashFile(t *testing.T) {
 	}
 }
 
-func TestHashFileChunks(t *testing.T) {
+func TestHashFileStreamFromFile(t *testing.T) {
 	// Create a temporary file for testing
 	tempFile, err := os.CreateTemp("", "testfile")
 	if err != nil {
@@ -62,12 +68,84 @@ func TestHashFileChunks(t *testing.T) {
 		}
 	}
 
-	var actualChunkHashes [][20]byte
-	// Call the HashFileChunks function with the temporary file
-	_, err = HashFileChunks(tempFile, &actualChunkHashes)
+	// Rewind the file to the beginning before hashing
+	_, err = tempFile.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatalf("Error seeking to the beginning of the file: %v", err)
+	}
+
+	content := []byte(strings.Repeat(testContent, 1000))
+	sha256Hasher, _ := HasherFromAlgorithm(protocol.HashAlgorithmSHA256)
+
+	// Call the HashFileStream function with the temporary file
+	_, chunkHashes, err := HashFileStream(tempFile, uint64(len(content)), sha256Hasher, nil)
 	if err != nil {
 		t.Fatalf("Error hashing file chunks: %v", err)
 	}
+
+	if len(chunkHashes) != 3 {
+		t.Fatalf("Expected 3 chunk hashes, got %d", len(chunkHashes))
+	}
+
+	for i, chunkHash := range chunkHashes {
+		end := min((i+1)*16000, len(content))
+
+		expected := sha256.Sum256(content[i*16000 : end])
+		if !bytes.Equal(chunkHash, expected[:]) {
+			t.Errorf("Expected hash of chunk %d to be %x, got %x", i, expected, chunkHash)
+		}
+	}
+}
+
+func TestHashFileStream(t *testing.T) {
+	hasher, _ := HasherFromAlgorithm(protocol.HashAlgorithmSHA1)
+
+	// Content spanning a few chunks, the last one being smaller
+	content := make([]byte, 3*16000+123)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+
+	var lastProgress uint64
+	fileHash, chunkHashes, err := HashFileStream(bytes.NewReader(content), uint64(len(content)), hasher, func(hashed uint64) {
+		if hashed <= lastProgress {
+			t.Errorf("Expected progress to increase, got %d after %d", hashed, lastProgress)
+		}
+		lastProgress = hashed
+	})
+	if err != nil {
+		t.Fatalf("Error hashing file: %v", err)
+	}
+
+	if fileHash != sha1.Sum(content) {
+		t.Errorf("Expected file hash %x, got %x", sha1.Sum(content), fileHash)
+	}
+
+	if len(chunkHashes) != 4 {
+		t.Fatalf("Expected 4 chunk hashes, got %d", len(chunkHashes))
+	}
+
+	for i, chunkHash := range chunkHashes {
+		end := (i + 1) * 16000
+		if end > len(content) {
+			end = len(content)
+		}
+
+		expected := sha1.Sum(content[i*16000 : end])
+		if !bytes.Equal(chunkHash, expected[:]) {
+			t.Errorf("Expected hash of chunk %d to be %x, got %x", i, expected, chunkHash)
+		}
+	}
+
+	if lastProgress != uint64(len(content)) {
+		t.Errorf("Expected progress to reach %d, got %d", len(content), lastProgress)
+	}
+
+	// A file shorter than its size can not be hashed
+	_, _, err = HashFileStream(bytes.NewReader(content[:100]), uint64(len(content)), hasher, nil)
+	if err == nil {
+		t.Errorf("Expected error hashing truncated file")
+	}
 }
 
 func TestChunkSize(t *testing.T) {
@@ -90,3 +168,104 @@ func TestChunkSize(t *testing.T) {
 		}
 	}
 }
+
+func TestMerkleTreeProofs(t *testing.T) {
+	// Test with a number of chunks which is not a power of two
+	hasher, _ := HasherFromAlgorithm(protocol.HashAlgorithmBLAKE2b)
+
+	chunkHashes := make([][]byte, 5)
+	for i := range chunkHashes {
+		chunkHashes[i] = HashChunk([]byte{byte(i)}, hasher)
+	}
+
+	tree := NewMerkleTree(hasher, chunkHashes)
+	partial := NewPartialMerkleTree(hasher, tree.Root(), len(chunkHashes))
+
+	// The partial tree can not provide proofs of chunks it has not verified yet
+	if _, ok := partial.Proof(0); ok {
+		t.Errorf("Expected no proof for an unverified chunk")
+	}
+
+	for _, i := range []int{3, 0, 4, 1, 2} {
+		proof, ok := tree.Proof(i)
+		if !ok {
+			t.Fatalf("Expected proof for chunk %d", i)
+		}
+
+		// A wrong hash with a valid proof must be rejected
+		if partial.Verify(i, HashChunk([]byte("wrong"), hasher), proof) {
+			t.Errorf("Expected wrong hash of chunk %d to be rejected", i)
+		}
+
+		if !partial.Verify(i, chunkHashes[i], proof) {
+			t.Errorf("Expected chunk %d to be verified", i)
+		}
+
+		// A verified chunk can be served to other nodes
+		if _, ok := partial.Proof(i); !ok {
+			t.Errorf("Expected proof for verified chunk %d", i)
+		}
+	}
+
+	nodes, known := partial.Nodes()
+	restored, err := RestoreMerkleTree(hasher, len(chunkHashes), nodes, known)
+	if err != nil {
+		t.Fatalf("Error restoring merkle tree: %v", err)
+	}
+
+	for i := range chunkHashes {
+		leaf, ok := restored.Leaf(i)
+		if !ok || !bytes.Equal(leaf, chunkHashes[i]) {
+			t.Errorf("Expected leaf %d to be %x, got %x", i, chunkHashes[i], leaf)
+		}
+	}
+}
+
+func TestMerkleTreeSingleChunk(t *testing.T) {
+	hasher, _ := HasherFromAlgorithm(protocol.HashAlgorithmSHA1)
+	chunkHash := HashChunk([]byte("chunk"), hasher)
+	tree := NewMerkleTree(hasher, [][]byte{chunkHash})
+
+	if !bytes.Equal(tree.Root(), chunkHash) {
+		t.Errorf("Expected root of a single chunk to be its hash")
+	}
+
+	partial := NewPartialMerkleTree(hasher, tree.Root(), 1)
+	if !partial.Verify(0, chunkHash, nil) {
+		t.Errorf("Expected single chunk to be verified without proof")
+	}
+}
+
+func TestHashers(t *testing.T) {
+	// Known digests of "abc"
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"sha1", "a9993e364706816aba3e25717850c26c9cd0d89d"},
+		{"sha256", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"blake2b", "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+	}
+
+	for _, tc := range testCases {
+		hasher, err := HasherFromName(tc.name)
+		if err != nil {
+			t.Fatalf("Error getting hasher %s: %v", tc.name, err)
+		}
+
+		digest := fmt.Sprintf("%x", HashChunk([]byte("abc"), hasher))
+		if digest != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, digest)
+		}
+
+		// File identifiers are the first 20 bytes of the digest
+		id, err := HashFile(strings.NewReader("abc"), hasher)
+		if err != nil || fmt.Sprintf("%x", id) != tc.expected[:40] {
+			t.Errorf("%s: expected file id %s, got %x", tc.name, tc.expected[:40], id)
+		}
+	}
+
+	if _, err := HasherFromAlgorithm(255); err == nil {
+		t.Errorf("Expected unknown hash algorithm to be rejected")
+	}
+}
@@ -0,0 +1,267 @@
+package wal
+
+import (
+	"PessiTorrent/internal/protocol"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	Permissions = 0644
+
+	// Size of the header written before every entry: length (uint32) + checksum (uint32) + version (uint16)
+	headerSize = 10
+)
+
+var ErrVersionMismatch = errors.New("record version mismatch")
+
+// Log is an append-only file of records of type T, serialized with the protocol serializer.
+// Every entry is framed as [length][crc32][version][payload], so a torn write at the end of the
+// file (e.g. after a crash) is detected and discarded on replay.
+// The version identifies the layout of T, so records written with an older layout are
+// reported instead of being mistaken for corrupted ones.
+type Log[T any] struct {
+	sync.Mutex
+	path    string
+	file    *os.File
+	version uint16
+	entries int
+}
+
+func Open[T any](path string, version uint16) (*Log[T], error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, Permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log[T]{
+		path:    path,
+		file:    file,
+		version: version,
+	}, nil
+}
+
+// Append serializes the record and synchronously writes it to the end of the log
+func (l *Log[T]) Append(record *T) error {
+	l.Lock()
+	defer l.Unlock()
+
+	entry, err := encodeEntry(record, l.version)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	_, err = l.file.Write(entry)
+	if err != nil {
+		return err
+	}
+
+	l.entries++
+
+	return l.file.Sync()
+}
+
+// Replay calls apply for every valid entry in the log, in order.
+// If the log ends with a corrupted or incomplete entry, the log is truncated right before it.
+// Entries which cannot be decoded, or were written with another version, are returned as an error
+// and the log is left untouched.
+func (l *Log[T]) Replay(apply func(record T)) error {
+	l.Lock()
+	defer l.Unlock()
+
+	_, err := l.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	entries, offset, err := readEntries(l.file, l.version, apply)
+	if err != nil {
+		return err
+	}
+	l.entries = entries
+
+	stats, err := l.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if stats.Size() != offset {
+		err = l.file.Truncate(offset)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Truncate discards every entry in the log
+func (l *Log[T]) Truncate() error {
+	l.Lock()
+	defer l.Unlock()
+
+	err := l.file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	l.entries = 0
+
+	return l.file.Sync()
+}
+
+// Len returns the number of entries currently in the log
+func (l *Log[T]) Len() int {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.entries
+}
+
+func (l *Log[T]) Close() error {
+	return l.file.Close()
+}
+
+// WriteFile atomically replaces the file at the given path with the given records,
+// using the same format as the log
+func WriteFile[T any](path string, version uint16, records []T) error {
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, Permissions)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	for i := range records {
+		entry, err := encodeEntry(&records[i], version)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		_, err = writer.Write(entry)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// ReadFile calls apply for every valid entry in the file written by WriteFile.
+// A missing file is treated as an empty one.
+func ReadFile[T any](path string, version uint16, apply func(record T)) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, _, err = readEntries(file, version, apply)
+	return err
+}
+
+func encodeEntry[T any](record *T, version uint16) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	err := protocol.SerializeStruct(payload, record)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing record: %w", err)
+	}
+
+	entry := make([]byte, headerSize, headerSize+payload.Len())
+	binary.LittleEndian.PutUint32(entry[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint16(entry[8:10], version)
+	binary.LittleEndian.PutUint32(entry[4:8], checksum(entry[8:10], payload.Bytes()))
+
+	return append(entry, payload.Bytes()...), nil
+}
+
+// Checksum of an entry, covering its version and payload
+func checksum(version []byte, payload []byte) uint32 {
+	return crc32.Update(crc32.ChecksumIEEE(version), crc32.IEEETable, payload)
+}
+
+// Reads entries until the end of the reader or the first torn entry (incomplete or with a wrong checksum).
+// Returns the number of valid entries and the offset right after the last one.
+func readEntries[T any](reader io.Reader, version uint16, apply func(record T)) (int, int64, error) {
+	bufReader := bufio.NewReader(reader)
+	header := make([]byte, headerSize)
+
+	var entries int
+	var offset int64
+
+	for {
+		_, err := io.ReadFull(bufReader, header)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return entries, offset, nil
+		}
+		if err != nil {
+			return entries, offset, err
+		}
+
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		entryVersion := binary.LittleEndian.Uint16(header[8:10])
+
+		payload := make([]byte, size)
+		_, err = io.ReadFull(bufReader, payload)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return entries, offset, nil
+		}
+		if err != nil {
+			return entries, offset, err
+		}
+
+		if checksum(header[8:10], payload) != sum {
+			return entries, offset, nil
+		}
+
+		// A complete entry with a valid checksum is not a torn write, so it must not be discarded
+		if entryVersion != version {
+			return entries, offset, fmt.Errorf("%w: entry at offset %d has version %d, expected %d", ErrVersionMismatch, offset, entryVersion, version)
+		}
+
+		var record T
+		err = protocol.DeserializeToStruct(bytes.NewReader(payload), &record)
+		if err != nil {
+			return entries, offset, fmt.Errorf("error deserializing entry at offset %d: %w", offset, err)
+		}
+
+		apply(record)
+		entries++
+		offset += int64(headerSize) + int64(size)
+	}
+}
@@ -0,0 +1,198 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testVersion = 1
+
+type testRecord struct {
+	Type  uint8
+	Name  string
+	Items []uint16
+}
+
+func TestAppendAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	log, err := Open[testRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error opening log: %v", err)
+	}
+
+	records := []testRecord{
+		{Type: 1, Name: "first", Items: []uint16{1, 2, 3}},
+		{Type: 2, Name: "second", Items: []uint16{}},
+	}
+
+	for i := range records {
+		err = log.Append(&records[i])
+		if err != nil {
+			t.Fatalf("Error appending record: %v", err)
+		}
+	}
+	log.Close()
+
+	log, err = Open[testRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error reopening log: %v", err)
+	}
+	defer log.Close()
+
+	var replayed []testRecord
+	err = log.Replay(func(record testRecord) {
+		replayed = append(replayed, record)
+	})
+	if err != nil {
+		t.Fatalf("Error replaying log: %v", err)
+	}
+
+	if !reflect.DeepEqual(records, replayed) {
+		t.Errorf("Expected %v, got %v", records, replayed)
+	}
+
+	if log.Len() != len(records) {
+		t.Errorf("Expected %d entries, got %d", len(records), log.Len())
+	}
+}
+
+func TestReplayDiscardsTornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	log, err := Open[testRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error opening log: %v", err)
+	}
+
+	err = log.Append(&testRecord{Type: 1, Name: "complete"})
+	if err != nil {
+		t.Fatalf("Error appending record: %v", err)
+	}
+	log.Close()
+
+	stats, _ := os.Stat(path)
+	validSize := stats.Size()
+
+	// Simulate a crash in the middle of writing a second entry
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, Permissions)
+	_, _ = file.Write([]byte{20, 0, 0, 0, 1, 2})
+	file.Close()
+
+	log, err = Open[testRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error reopening log: %v", err)
+	}
+	defer log.Close()
+
+	var replayed []testRecord
+	err = log.Replay(func(record testRecord) {
+		replayed = append(replayed, record)
+	})
+	if err != nil {
+		t.Fatalf("Error replaying log: %v", err)
+	}
+
+	if len(replayed) != 1 || replayed[0].Name != "complete" {
+		t.Errorf("Expected only the complete record, got %v", replayed)
+	}
+
+	stats, _ = os.Stat(path)
+	if stats.Size() != validSize {
+		t.Errorf("Expected log to be truncated to %d bytes, got %d", validSize, stats.Size())
+	}
+}
+
+func TestWriteAndReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.snapshot")
+
+	records := []testRecord{
+		{Type: 1, Name: "a", Items: []uint16{7}},
+		{Type: 1, Name: "b", Items: []uint16{8, 9}},
+	}
+
+	err := WriteFile(path, testVersion, records)
+	if err != nil {
+		t.Fatalf("Error writing file: %v", err)
+	}
+
+	var read []testRecord
+	err = ReadFile(path, testVersion, func(record testRecord) {
+		read = append(read, record)
+	})
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	if !reflect.DeepEqual(records, read) {
+		t.Errorf("Expected %v, got %v", records, read)
+	}
+}
+
+func TestReplayRejectsUndecodableEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	// Record with a layout the test record cannot be decoded from
+	type otherRecord struct {
+		Type uint8
+	}
+
+	other, err := Open[otherRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error opening log: %v", err)
+	}
+
+	err = other.Append(&otherRecord{Type: 1})
+	if err != nil {
+		t.Fatalf("Error appending record: %v", err)
+	}
+	other.Close()
+
+	stats, _ := os.Stat(path)
+	size := stats.Size()
+
+	log, err := Open[testRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error reopening log: %v", err)
+	}
+	defer log.Close()
+
+	err = log.Replay(func(record testRecord) {})
+	if err == nil {
+		t.Errorf("Expected an error replaying an undecodable entry")
+	}
+
+	stats, _ = os.Stat(path)
+	if stats.Size() != size {
+		t.Errorf("Expected log to be kept with %d bytes, got %d", size, stats.Size())
+	}
+}
+
+func TestReplayRejectsOtherVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+
+	log, err := Open[testRecord](path, testVersion)
+	if err != nil {
+		t.Fatalf("Error opening log: %v", err)
+	}
+
+	err = log.Append(&testRecord{Type: 1, Name: "old"})
+	if err != nil {
+		t.Fatalf("Error appending record: %v", err)
+	}
+	log.Close()
+
+	log, err = Open[testRecord](path, testVersion+1)
+	if err != nil {
+		t.Fatalf("Error reopening log: %v", err)
+	}
+	defer log.Close()
+
+	err = log.Replay(func(record testRecord) {})
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Expected a version mismatch error, got %v", err)
+	}
+}